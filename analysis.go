@@ -0,0 +1,257 @@
+package calculon
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Variadic is the Max of the arity of functions taking any number of arguments.
+const Variadic = -1
+
+// Arity tells how many arguments a function takes, from Min to Max,
+// or one of Counts for functions such as sum, which takes 1 or 3.
+type Arity struct {
+	Min, Max int
+	// Counts lists the numbers of arguments taken in place of Min and Max, if set
+	Counts []int
+}
+
+// Accepts reports whether n arguments are fine.
+func (a Arity) Accepts(n int) bool {
+	if len(a.Counts) > 0 {
+		for _, count := range a.Counts {
+			if n == count {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return n >= a.Min && (a.Max == Variadic || n <= a.Max)
+}
+
+// String describes the arity the way errors of functions do: 1 arg, 1 or 2 args.
+func (a Arity) String() string {
+	plural := func(n int) string {
+		if n == 1 {
+			return "1 arg"
+		}
+
+		return strconv.Itoa(n) + " args"
+	}
+
+	switch {
+	case len(a.Counts) > 0:
+		counts := make([]string, len(a.Counts))
+		for i, count := range a.Counts {
+			counts[i] = strconv.Itoa(count)
+		}
+
+		last := len(counts) - 1
+		counts[last] = plural(a.Counts[last])
+		if last == 0 {
+			return counts[0]
+		}
+
+		return strings.Join(counts[:last], ", ") + " or " + counts[last]
+	case a.Max == Variadic:
+		return "at least " + plural(a.Min)
+	case a.Min == a.Max:
+		return plural(a.Min)
+	case a.Min+1 == a.Max:
+		return strconv.Itoa(a.Min) + " or " + plural(a.Max)
+	default:
+		return strconv.Itoa(a.Min) + " to " + plural(a.Max)
+	}
+}
+
+// arityMutable is implemented by contexts that record the arities of functions
+// defined in them, such as Scope.
+type arityMutable interface {
+	SetArity(name string, arity Arity)
+}
+
+// funcArity returns the arity of the function expr stands for, see funcValue.
+func funcArity(ctx EvalContext, expr Expression) (Arity, bool) {
+	switch expr := unparen(expr).(type) {
+	case Lambda:
+		return Arity{Min: len(expr.Params), Max: len(expr.Params)}, true
+	case Variable:
+		return lookupArity(ctx, expr.Name)
+	}
+
+	return Arity{}, false
+}
+
+// binder visits an expression with the names bound where it is: the parameters
+// of lambdas and function definitions, and the names let and assignments define.
+type binder struct {
+	vars  map[string]bool
+	funcs map[string]*Arity // nil for functions of unknown arity
+	visit func(b *binder, expr Expression)
+}
+
+func newBinder(visit func(b *binder, expr Expression)) *binder {
+	return &binder{vars: map[string]bool{}, funcs: map[string]*Arity{}, visit: visit}
+}
+
+// inner returns a binder for a nested scope, names bound in it stay there.
+func (b *binder) inner() *binder {
+	inner := &binder{vars: make(map[string]bool, len(b.vars)), funcs: make(map[string]*Arity, len(b.funcs)), visit: b.visit}
+	for name := range b.vars {
+		inner.vars[name] = true
+	}
+
+	for name, arity := range b.funcs {
+		inner.funcs[name] = arity
+	}
+
+	return inner
+}
+
+// params binds parameters, which may stand for numbers or functions.
+func (b *binder) params(params []string) *binder {
+	inner := b.inner()
+	for _, param := range params {
+		inner.vars[param] = true
+		inner.funcs[param] = nil
+	}
+
+	return inner
+}
+
+func (b *binder) Visit(expr Expression) Visitor {
+	switch expr := expr.(type) {
+	case nil:
+		return nil
+	case Lambda:
+		return b.params(expr.Params)
+	case FunctionDef:
+		// the body sees the function itself, so that it may recurse
+		b.funcs[expr.Name] = &Arity{Min: len(expr.Params), Max: len(expr.Params)}
+		delete(b.vars, expr.Name)
+		return b.params(expr.Params)
+	case Assignment:
+		// the value only sees the names bound before, except for lambdas,
+		// which may call themselves: f = n -> n < 1 ? 1 : n * f(n - 1)
+		if lambda, ok := unparen(expr.Value).(Lambda); ok {
+			b.funcs[expr.Name] = &Arity{Min: len(lambda.Params), Max: len(lambda.Params)}
+			delete(b.vars, expr.Name)
+			return b.inner()
+		}
+
+		value := b.inner()
+		b.vars[expr.Name] = true
+		if _, ok := unparen(expr.Value).(Variable); ok {
+			// g = sin may define a function as well as copy a number
+			b.funcs[expr.Name] = nil
+		} else {
+			delete(b.funcs, expr.Name)
+		}
+
+		return value
+	case Let:
+		return b.inner()
+	}
+
+	b.visit(b, expr)
+	return b
+}
+
+// inspectFree calls fn for the variables and function calls of expr that are
+// not bound in expr, and for calls of functions defined in expr with the wrong
+// number of arguments, along with their arity.
+func inspectFree(expr Expression, fn func(expr Expression, arity *Arity)) {
+	Walk(newBinder(func(b *binder, expr Expression) {
+		switch e := expr.(type) {
+		case Variable:
+			if _, isFunc := b.funcs[e.Name]; !b.vars[e.Name] && !isFunc {
+				fn(e, nil)
+			}
+		case FunctionCall:
+			arity, bound := b.funcs[e.Name]
+			if !bound {
+				fn(e, nil)
+			} else if arity != nil && !arity.Accepts(len(e.Args)) {
+				fn(e, arity)
+			}
+		}
+	}), expr)
+}
+
+// Vars returns the names of the variables expr needs, in the order they
+// first appear in. Names bound in expr, such as lambda parameters, are left out.
+// Functions passed by name, as sin is in apply(sin, 1), count as variables.
+func Vars(expr Expression) []string {
+	var names []string
+	seen := map[string]bool{}
+	inspectFree(expr, func(expr Expression, _ *Arity) {
+		if v, ok := expr.(Variable); ok && !seen[v.Name] {
+			seen[v.Name] = true
+			names = append(names, v.Name)
+		}
+	})
+
+	return names
+}
+
+// Funcs returns the names of the functions expr calls, in the order they
+// first appear in. Functions defined in expr are left out.
+func Funcs(expr Expression) []string {
+	var names []string
+	seen := map[string]bool{}
+	inspectFree(expr, func(expr Expression, arity *Arity) {
+		if call, ok := expr.(FunctionCall); ok && arity == nil && !seen[call.Name] {
+			seen[call.Name] = true
+			names = append(names, call.Name)
+		}
+	})
+
+	return names
+}
+
+// Check reports, without evaluating expr, the names it would fail on in ctx:
+// variables and functions that are not specified, and calls with a number of
+// arguments the function does not take. Arities are known for the builtins,
+// for functions defined by programs and for those given to SetArity.
+// Check returns nil if it finds nothing wrong.
+func Check(expr Expression, ctx EvalContext) []error {
+	var errs []error
+	inspectFree(expr, func(expr Expression, local *Arity) {
+		switch e := expr.(type) {
+		case Variable:
+			if _, found := lookupValue(ctx, e.Name); found {
+				return
+			}
+
+			// functions are passed by name: apply(sin, 1)
+			if _, found := lookupAnyFunc(ctx, e.Name); found {
+				return
+			}
+
+			errs = append(errs, fmt.Errorf("variable not specified: %s", e.Name))
+		case FunctionCall:
+			if local != nil {
+				errs = append(errs, arityError(e, *local))
+				return
+			}
+
+			if _, found := lookupAnyFunc(ctx, e.Name); !found {
+				errs = append(errs, fmt.Errorf("function not specified: %s", e.Name))
+				return
+			}
+
+			if arity, found := lookupArity(ctx, e.Name); found && !arity.Accepts(len(e.Args)) {
+				errs = append(errs, arityError(e, arity))
+			}
+		}
+	})
+
+	return errs
+}
+
+func arityError(call FunctionCall, arity Arity) error {
+	return fmt.Errorf("%s() requires %s, got %d", call.Name, arity, len(call.Args))
+}
@@ -0,0 +1,510 @@
+package calculon
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+const KindBigFloat Kind = "bigfloat"
+
+// guardBits is the extra precision functions of big floats compute with,
+// so that their results are correct to the precision asked for.
+const guardBits = 64
+
+// BigFloat is a floating-point number of arbitrary precision, see PrecisionMode.
+// It prints with as many significant digits as its precision holds.
+type BigFloat struct {
+	*big.Float
+}
+
+func (f BigFloat) Kind() Kind { return KindBigFloat }
+
+func (f BigFloat) String() string {
+	return f.Text('g', decimalDigits(f.Prec()))
+}
+
+// Format prints %v and %s as String does, and the other verbs as big.Float.Format.
+func (f BigFloat) Format(s fmt.State, verb rune) {
+	if verb == 'v' || verb == 's' {
+		fmt.Fprint(s, f.String())
+		return
+	}
+
+	f.Float.Format(s, verb)
+}
+
+func (f BigFloat) float() (float64, bool) {
+	x, _ := f.Float64()
+	return x, true
+}
+
+// decimalDigits returns how many significant decimal digits prec bits hold.
+func decimalDigits(prec uint) int {
+	if digits := int(float64(prec) * math.Log10(2)); digits > 1 {
+		return digits
+	}
+
+	return 1
+}
+
+func newBig(prec uint) *big.Float {
+	return new(big.Float).SetPrec(prec)
+}
+
+// bigFloatOps are the operators computed with big floats. The others,
+// such as the factorial, treat big floats as float64 numbers.
+var bigFloatOps = map[string]func(prec uint, l, r *big.Float) (Value, error){
+	"+":  func(prec uint, l, r *big.Float) (Value, error) { return BigFloat{newBig(prec).Add(l, r)}, nil },
+	"-":  func(prec uint, l, r *big.Float) (Value, error) { return BigFloat{newBig(prec).Sub(l, r)}, nil },
+	"*":  func(prec uint, l, r *big.Float) (Value, error) { return BigFloat{newBig(prec).Mul(l, r)}, nil },
+	"==": func(prec uint, l, r *big.Float) (Value, error) { return bigTruth(prec, l.Cmp(r) == 0), nil },
+	"!=": func(prec uint, l, r *big.Float) (Value, error) { return bigTruth(prec, l.Cmp(r) != 0), nil },
+	"<":  func(prec uint, l, r *big.Float) (Value, error) { return bigTruth(prec, l.Cmp(r) < 0), nil },
+	"<=": func(prec uint, l, r *big.Float) (Value, error) { return bigTruth(prec, l.Cmp(r) <= 0), nil },
+	">":  func(prec uint, l, r *big.Float) (Value, error) { return bigTruth(prec, l.Cmp(r) > 0), nil },
+	">=": func(prec uint, l, r *big.Float) (Value, error) { return bigTruth(prec, l.Cmp(r) >= 0), nil },
+	"&&": func(prec uint, l, r *big.Float) (Value, error) {
+		return bigTruth(prec, l.Sign() != 0 && r.Sign() != 0), nil
+	},
+	"||": func(prec uint, l, r *big.Float) (Value, error) {
+		return bigTruth(prec, l.Sign() != 0 || r.Sign() != 0), nil
+	},
+	"xor": func(prec uint, l, r *big.Float) (Value, error) {
+		return bigTruth(prec, (l.Sign() != 0) != (r.Sign() != 0)), nil
+	},
+	"/": func(prec uint, l, r *big.Float) (Value, error) {
+		if r.Sign() == 0 {
+			return nil, fmt.Errorf("divide by zero")
+		}
+
+		return BigFloat{newBig(prec).Quo(l, r)}, nil
+	},
+	"^": func(prec uint, l, r *big.Float) (Value, error) {
+		return bigResult(bigPow(prec, l, r)), nil
+	},
+}
+
+func init() {
+	for op, fn := range bigFloatOps {
+		op, fn := op, fn
+		eval := func(l, r Value) (Value, error) {
+			lb, lok := toBig(l)
+			rb, rok := toBig(r)
+			if !lok || !rok {
+				// NaN has no big float
+				left, _ := realValue(l)
+				right, _ := realValue(r)
+				return mapBinary(infixOps[op].eval, left, right)
+			}
+
+			return bigOp(func() (Value, error) {
+				return fn(maxPrec(lb, rb), lb, rb)
+			})
+		}
+
+		infixValueOps[infixKey{op: op, left: KindBigFloat, right: KindBigFloat}] = eval
+		infixValueOps[infixKey{op: op, left: KindBigFloat, right: KindNumber}] = eval
+		infixValueOps[infixKey{op: op, left: KindNumber, right: KindBigFloat}] = eval
+	}
+
+	unaryValueOps[unaryKey{op: "-", kind: KindBigFloat}] = func(x Value) (Value, error) {
+		f := x.(BigFloat)
+		return BigFloat{newBig(f.Prec()).Neg(f.Float)}, nil
+	}
+	unaryValueOps[unaryKey{op: "+", kind: KindBigFloat}] = func(x Value) (Value, error) { return x, nil }
+	unaryValueOps[unaryKey{op: "!", kind: KindBigFloat}] = func(x Value) (Value, error) {
+		f := x.(BigFloat)
+		return bigTruth(f.Prec(), f.Sign() == 0), nil
+	}
+	unaryValueOps[unaryKey{op: "%", postfix: true, kind: KindBigFloat}] = func(x Value) (Value, error) {
+		f := x.(BigFloat)
+		return BigFloat{newBig(f.Prec()).Quo(f.Float, big.NewFloat(100))}, nil
+	}
+}
+
+// bigTruth is the big float counterpart of truth.
+func bigTruth(prec uint, b bool) BigFloat {
+	return BigFloat{newBig(prec).SetFloat64(truth(b))}
+}
+
+// toBig converts a big float or a float, unless it is NaN.
+func toBig(val Value) (*big.Float, bool) {
+	switch val := val.(type) {
+	case BigFloat:
+		return val.Float, true
+	case Float:
+		if math.IsNaN(float64(val)) {
+			return nil, false
+		}
+
+		return big.NewFloat(float64(val)), true
+	default:
+		return nil, false
+	}
+}
+
+func maxPrec(l, r *big.Float) uint {
+	if l.Prec() > r.Prec() {
+		return l.Prec()
+	}
+
+	return r.Prec()
+}
+
+// bigOp turns the panic of big float operations whose result would be NaN,
+// such as Inf - Inf, into a NaN float.
+func bigOp(fn func() (Value, error)) (val Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, nan := r.(big.ErrNaN); !nan {
+				panic(r)
+			}
+
+			val, err = Float(math.NaN()), nil
+		}
+	}()
+
+	return fn()
+}
+
+// bigResult wraps the result of a function of big floats, nil stands for NaN.
+func bigResult(f *big.Float) Value {
+	if f == nil {
+		return Float(math.NaN())
+	}
+
+	return BigFloat{f}
+}
+
+// bigPi computes Pi with the Gauss-Legendre algorithm.
+func bigPi(prec uint) *big.Float {
+	w := prec + guardBits
+	a := newBig(w).SetInt64(1)
+	b := newBig(w).Sqrt(newBig(w).SetFloat64(0.5))
+	t := newBig(w).SetFloat64(0.25)
+	p := newBig(w).SetInt64(1)
+
+	for i := 0; i < 64 && !closeEnough(newBig(w).Sub(a, b), w); i++ {
+		next := newBig(w).Add(a, b)
+		next.SetMantExp(next, -1)
+
+		b.Sqrt(newBig(w).Mul(a, b))
+
+		d := newBig(w).Sub(a, next)
+		t.Sub(t, d.Mul(d, d).Mul(d, p))
+
+		a = next
+		p.SetMantExp(p, 1)
+	}
+
+	pi := newBig(w).Add(a, b)
+	pi.Mul(pi, pi)
+
+	return pi.Quo(pi, t.SetMantExp(t, 2)).SetPrec(prec)
+}
+
+// closeEnough reports whether a correction d is below the precision w.
+func closeEnough(d *big.Float, w uint) bool {
+	return d.Sign() == 0 || d.MantExp(nil) < -int(w)
+}
+
+// bigExp computes e^x by summing the Taylor series for x/2^n, then squaring n times.
+func bigExp(prec uint, x *big.Float) *big.Float {
+	switch {
+	case x.IsInf():
+		if x.Sign() > 0 {
+			return newBig(prec).SetInf(false)
+		}
+
+		return newBig(prec)
+	case x.Sign() < 0:
+		y := bigExp(prec+guardBits, newBig(x.Prec()).Neg(x))
+		return newBig(prec).Quo(newBig(prec).SetInt64(1), y)
+	}
+
+	n := x.MantExp(nil) + 8
+	switch {
+	case n > 40:
+		// e^x overflows the exponent of big floats
+		return newBig(prec).SetInf(false)
+	case n < 0:
+		n = 0
+	}
+
+	w := prec + guardBits + uint(n)
+	r := newBig(w).SetMantExp(x, -n)
+
+	sum := newBig(w).SetInt64(1)
+	term := newBig(w).SetInt64(1)
+	for k := int64(1); ; k++ {
+		term.Mul(term, r)
+		term.Quo(term, newBig(w).SetInt64(k))
+		if closeEnough(term, w) {
+			break
+		}
+
+		sum.Add(sum, term)
+	}
+
+	for i := 0; i < n; i++ {
+		sum.Mul(sum, sum)
+	}
+
+	return sum.SetPrec(prec)
+}
+
+// bigLog computes the natural logarithm, it returns nil for negative x.
+// x = m*2^e with m in [0.5, 1), so log x = log m + e*log 2.
+func bigLog(prec uint, x *big.Float) *big.Float {
+	switch {
+	case x.Sign() < 0:
+		return nil
+	case x.Sign() == 0:
+		return newBig(prec).SetInf(true)
+	case x.IsInf():
+		return newBig(prec).SetInf(false)
+	}
+
+	w := prec + guardBits
+	m := newBig(w)
+	e := x.MantExp(m)
+
+	y := newtonLog(w, m)
+	if e != 0 {
+		ln2 := newtonLog(w, newBig(w).SetInt64(2))
+		y.Add(y, ln2.Mul(ln2, newBig(w).SetInt64(int64(e))))
+	}
+
+	return y.SetPrec(prec)
+}
+
+// newtonLog computes log v for v of moderate size, refining the float64
+// logarithm with Halley's iteration y += 2*(v - e^y)/(v + e^y).
+func newtonLog(w uint, v *big.Float) *big.Float {
+	f, _ := v.Float64()
+	y := newBig(w).SetFloat64(math.Log(f))
+
+	for i := 0; i < 64; i++ {
+		ey := bigExp(w, y)
+		d := newBig(w).Sub(v, ey)
+		d.Quo(d, ey.Add(v, ey))
+		d.SetMantExp(d, 1)
+		y.Add(y, d)
+
+		if closeEnough(d, w) {
+			break
+		}
+	}
+
+	return y
+}
+
+// bigSin computes sin x, reducing x to [-Pi, Pi] before summing the Taylor series.
+func bigSin(prec uint, x *big.Float) *big.Float {
+	return bigSinCos(prec, x, true)
+}
+
+// bigCos computes cos x, see bigSin.
+func bigCos(prec uint, x *big.Float) *big.Float {
+	return bigSinCos(prec, x, false)
+}
+
+func bigSinCos(prec uint, x *big.Float, sin bool) *big.Float {
+	if x.IsInf() {
+		return nil
+	}
+
+	w := prec + guardBits
+	if exp := x.MantExp(nil); exp > 0 {
+		// the reduction cancels the integer part of x/2Pi
+		w += uint(exp)
+	}
+
+	twoPi := bigPi(w)
+	twoPi.SetMantExp(twoPi, 1)
+
+	// the nearest integer number of turns
+	k := newBig(w).Quo(x, twoPi)
+	if k.Sign() >= 0 {
+		k.Add(k, big.NewFloat(0.5))
+	} else {
+		k.Sub(k, big.NewFloat(0.5))
+	}
+	turns, _ := k.Int(nil)
+
+	r := newBig(w).Mul(twoPi, newBig(w).SetInt(turns))
+	r.Sub(newBig(w).Set(x), r)
+	r2 := newBig(w).Mul(r, r)
+
+	// sin: r - r^3/3! + ..., cos: 1 - r^2/2! + ...
+	term := newBig(w).SetInt64(1)
+	k0 := int64(1)
+	if sin {
+		term.Set(r)
+		k0 = 2
+	}
+
+	sum := newBig(w).Set(term)
+	for k := k0; ; k += 2 {
+		term.Mul(term, r2)
+		term.Quo(term, newBig(w).SetInt64(k*(k+1)))
+		term.Neg(term)
+		if closeEnough(term, w) {
+			break
+		}
+
+		sum.Add(sum, term)
+	}
+
+	return sum.SetPrec(prec)
+}
+
+// bigPow computes l^r, exactly up to rounding for integer r,
+// and as e^(r*log l) otherwise. It returns nil for results that are NaN.
+func bigPow(prec uint, l, r *big.Float) *big.Float {
+	if r.IsInt() && !r.IsInf() {
+		if n, acc := r.Int64(); acc == big.Exact && n <= maxExactExponent && n >= -maxExactExponent {
+			return bigIntPow(prec, l, n)
+		}
+	}
+
+	switch {
+	case l.Sign() < 0:
+		return nil
+	case l.Sign() == 0:
+		if r.Sign() < 0 {
+			return newBig(prec).SetInf(false)
+		}
+
+		return newBig(prec)
+	}
+
+	w := prec + guardBits
+	y := bigLog(w, l)
+	y.Mul(y, r)
+	if exp := y.MantExp(nil); exp > 0 {
+		// the error of y grows with its size in e^y
+		y = bigLog(w+uint(exp), l)
+		y.Mul(y, r)
+	}
+
+	return bigExp(prec, y)
+}
+
+func bigIntPow(prec uint, x *big.Float, n int64) *big.Float {
+	neg := n < 0
+	if neg {
+		n = -n
+	}
+
+	w := prec + guardBits
+	result := newBig(w).SetInt64(1)
+	base := newBig(w).Set(x)
+	for ; n > 0; n >>= 1 {
+		if n&1 == 1 {
+			result.Mul(result, base)
+		}
+
+		base.Mul(base, base)
+	}
+
+	if neg {
+		if result.Sign() == 0 {
+			return newBig(prec).SetInf(false)
+		}
+
+		result.Quo(newBig(w).SetInt64(1), result)
+	}
+
+	return result.SetPrec(prec)
+}
+
+// bigFunc makes a function of one big float. Numbers are converted to big floats
+// of precision prec, fn returns nil for results that are NaN.
+func bigFunc(name string, prec uint, fn func(prec uint, x *big.Float) *big.Float) LazyValueFunction {
+	return eagerValue(func(args []Value) (Value, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s() requires 1 arg", name)
+		}
+
+		x, ok := toBig(args[0])
+		if !ok {
+			if f, ok := args[0].(Float); ok {
+				return f, nil // NaN
+			}
+
+			return nil, fmt.Errorf("%s(): not a number: %s", name, args[0])
+		}
+
+		p := prec
+		if b, ok := args[0].(BigFloat); ok {
+			p = b.Prec()
+		}
+
+		return bigOp(func() (Value, error) { return bigResult(fn(p, x)), nil })
+	})
+}
+
+// PrecisionMode evaluates the numbers of ctx as big floats with prec bits of mantissa.
+// Number literals are read with that precision, and the functions sin, cos, exp,
+// log, sqrt and abs, along with the constants Pi and E, are replaced with ones
+// of that precision. Other functions compute with float64 numbers.
+func PrecisionMode(ctx EvalContext, prec uint) EvalContext {
+	return &modeContext{
+		parent: ctx,
+		number: func(literal string, value float64) (Value, error) {
+			if literal == "" {
+				literal = formatFloat(value)
+			}
+
+			f, _, err := newBig(prec).Parse(literal, 0)
+			if err != nil {
+				return Float(value), nil // NaN
+			}
+
+			return BigFloat{f}, nil
+		},
+		funcs: map[string]LazyValueFunction{
+			"sin": bigFunc("sin", prec, bigSin),
+			"cos": bigFunc("cos", prec, bigCos),
+			"exp": bigFunc("exp", prec, bigExp),
+			"log": bigFunc("log", prec, bigLog),
+			"sqrt": bigFunc("sqrt", prec, func(prec uint, x *big.Float) *big.Float {
+				if x.Sign() < 0 {
+					return nil
+				}
+
+				return newBig(prec).Sqrt(x)
+			}),
+			"abs": bigFunc("abs", prec, func(prec uint, x *big.Float) *big.Float {
+				return newBig(prec).Abs(x)
+			}),
+		},
+		consts: map[string]Value{
+			"Pi": BigFloat{bigPi(prec)},
+			"E":  BigFloat{bigExp(prec, big.NewFloat(1))},
+		},
+	}
+}
+
+// EvalBigFloat evaluates expr in PrecisionMode(ctx, prec).
+func EvalBigFloat(expr Expression, ctx EvalContext, prec uint) (*big.Float, error) {
+	val, err := expr.EvalValue(PrecisionMode(ctx, prec))
+	if err != nil {
+		return nil, err
+	}
+
+	switch val := val.(type) {
+	case BigFloat:
+		return val.Float, nil
+	case Float:
+		if math.IsNaN(float64(val)) {
+			return nil, fmt.Errorf("result is not a number")
+		}
+
+		return newBig(prec).SetFloat64(float64(val)), nil
+	default:
+		return nil, fmt.Errorf("%s used as a number: %s", val.Kind(), val)
+	}
+}
@@ -3,10 +3,25 @@ package calculon
 import (
 	"fmt"
 	"math"
+	"math/cmplx"
 )
 
 type Function = func(args []float64) (float64, error)
 
+// maxSumTerms limits the number of terms of sum(f, a, b).
+const maxSumTerms = 10_000_000
+
+// LazyFunction receives its arguments unevaluated, so it can decide
+// which of them to evaluate in ctx, and when.
+type LazyFunction = func(ctx EvalContext, args []Expression) (float64, error)
+
+// ValueFunction is a Function taking and returning values of any kind.
+type ValueFunction = func(args []Value) (Value, error)
+
+// LazyValueFunction is a LazyFunction returning values of any kind.
+// User-defined functions and lambdas are of this sort.
+type LazyValueFunction = func(ctx EvalContext, args []Expression) (Value, error)
+
 var (
 	builtinVars = map[string]float64{
 		"Pi": math.Pi,
@@ -29,4 +44,316 @@ var (
 			return math.Cos(args[0]), nil
 		},
 	}
+
+	builtinValueFuncs = map[string]LazyValueFunction{
+		"if": func(ctx EvalContext, args []Expression) (Value, error) {
+			if len(args) != 3 {
+				return nil, fmt.Errorf("if() requires 3 args")
+			}
+
+			cond, err := condArg(ctx, args[0])
+			if err != nil {
+				return nil, err
+			}
+
+			if cond {
+				return args[1].EvalValue(ctx)
+			}
+
+			return args[2].EvalValue(ctx)
+		},
+		// apply calls the function given by the first argument with the rest: apply(x -> x^2, 3)
+		"apply": func(ctx EvalContext, args []Expression) (Value, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("apply() requires at least 1 arg")
+			}
+
+			fn, ok := funcValue(ctx, args[0])
+			if !ok {
+				return nil, fmt.Errorf("apply(): not a function: %s", args[0])
+			}
+
+			return fn(ctx, args[1:])
+		},
+		// round(x, n) rounds x to n digits after the point, ties to even, round(x) to an integer
+		"round": eagerValue(builtinRound),
+		// mean is the average of its args, numbers or vectors: mean(v), mean(1, 2, 3)
+		"mean": mean,
+		// functions of complex numbers, they take real ones as well
+		"re":    complexFunc("re", func(x complex128) Value { return Float(real(x)) }),
+		"im":    complexFunc("im", func(x complex128) Value { return Float(imag(x)) }),
+		"abs":   eagerValue(abs),
+		"arg":   complexFunc("arg", func(x complex128) Value { return Float(cmplx.Phase(x)) }),
+		"conj":  complexFunc("conj", func(x complex128) Value { return Complex(cmplx.Conj(x)) }),
+		"cexp":  complexFunc("cexp", func(x complex128) Value { return Complex(cmplx.Exp(x)) }),
+		"csqrt": complexFunc("csqrt", func(x complex128) Value { return Complex(cmplx.Sqrt(x)) }),
+	}
+
+	// builtinArities tell how many arguments the builtin functions take, see Check
+	builtinArities = map[string]Arity{
+		"sin":      {Min: 1, Max: 1},
+		"cos":      {Min: 1, Max: 1},
+		"if":       {Min: 3, Max: 3},
+		"apply":    {Min: 1, Max: Variadic},
+		"round":    {Min: 1, Max: 2},
+		"re":       {Min: 1, Max: 1},
+		"im":       {Min: 1, Max: 1},
+		"abs":      {Min: 1, Max: 1},
+		"arg":      {Min: 1, Max: 1},
+		"conj":     {Min: 1, Max: 1},
+		"cexp":     {Min: 1, Max: 1},
+		"csqrt":    {Min: 1, Max: 1},
+		"coalesce": {Min: 1, Max: Variadic},
+		"sum":      {Counts: []int{1, 3}},
+		"len":      {Min: 1, Max: 1},
+		"mean":     {Min: 1, Max: Variadic},
+		"dot":      {Min: 2, Max: 2},
+		"norm":     {Min: 1, Max: 1},
+		"and":      {Min: 0, Max: Variadic},
+		"or":       {Min: 0, Max: Variadic},
+	}
+
+	builtinLazyFuncs = map[string]LazyFunction{
+		// coalesce returns the first argument that evaluates to a number
+		"coalesce": func(ctx EvalContext, args []Expression) (float64, error) {
+			if len(args) == 0 {
+				return 0, fmt.Errorf("coalesce() requires at least 1 arg")
+			}
+
+			var err error
+			for _, arg := range args {
+				var val float64
+				if val, err = arg.Eval(ctx); err == nil && !math.IsNaN(val) {
+					return val, nil
+				}
+			}
+
+			if err != nil {
+				return 0, err
+			}
+
+			return math.NaN(), nil
+		},
+		// sum adds up the elements of a vector, sum([1, 2, 3]),
+		// or f(i) for the integers i from a to b, sum(i -> i^2, 1, 10)
+		"sum": func(ctx EvalContext, args []Expression) (float64, error) {
+			if len(args) == 1 {
+				v, err := vectorArg(ctx, "sum", args[0])
+				if err != nil {
+					return 0, err
+				}
+
+				var total float64
+				for _, x := range v {
+					total += x
+				}
+
+				return total, nil
+			}
+
+			if len(args) != 3 {
+				return 0, fmt.Errorf("sum() requires 1 or 3 args")
+			}
+
+			fn, ok := funcValue(ctx, args[0])
+			if !ok {
+				return 0, fmt.Errorf("sum(): not a function: %s", args[0])
+			}
+
+			from, err := args[1].Eval(ctx)
+			if err != nil {
+				return 0, err
+			}
+
+			to, err := args[2].Eval(ctx)
+			if err != nil {
+				return 0, err
+			}
+
+			if math.IsInf(from, 0) || math.IsInf(to, 0) || math.IsNaN(from) || math.IsNaN(to) {
+				return 0, fmt.Errorf("sum(): bounds must be finite: %g, %g", from, to)
+			}
+
+			// counting with floats would stop at 2^53, where i+1 == i
+			start := math.Ceil(from)
+			terms := math.Floor(to) - start + 1
+			if terms > maxSumTerms {
+				return 0, fmt.Errorf("sum(): too many terms: %g", terms)
+			}
+
+			var total float64
+			for k := 0; k < int(terms); k++ {
+				val, err := number(fn(ctx, []Expression{Number{Value: start + float64(k)}}))
+				if err != nil {
+					return 0, err
+				}
+
+				total += val
+			}
+
+			return total, nil
+		},
+		"len": func(ctx EvalContext, args []Expression) (float64, error) {
+			if len(args) != 1 {
+				return 0, fmt.Errorf("len() requires 1 arg")
+			}
+
+			v, err := vectorArg(ctx, "len", args[0])
+			return float64(len(v)), err
+		},
+		"dot": func(ctx EvalContext, args []Expression) (float64, error) {
+			if len(args) != 2 {
+				return 0, fmt.Errorf("dot() requires 2 args")
+			}
+
+			a, err := vectorArg(ctx, "dot", args[0])
+			if err != nil {
+				return 0, err
+			}
+
+			b, err := vectorArg(ctx, "dot", args[1])
+			if err != nil {
+				return 0, err
+			}
+
+			if len(a) != len(b) {
+				return 0, fmt.Errorf("dot(): vector lengths differ: %d and %d", len(a), len(b))
+			}
+
+			var product float64
+			for i := range a {
+				product += a[i] * b[i]
+			}
+
+			return product, nil
+		},
+		// norm is the euclidean length of a vector
+		"norm": func(ctx EvalContext, args []Expression) (float64, error) {
+			if len(args) != 1 {
+				return 0, fmt.Errorf("norm() requires 1 arg")
+			}
+
+			v, err := vectorArg(ctx, "norm", args[0])
+			if err != nil {
+				return 0, err
+			}
+
+			var norm float64
+			for _, x := range v {
+				norm = math.Hypot(norm, x)
+			}
+
+			return norm, nil
+		},
+		"and": func(ctx EvalContext, args []Expression) (float64, error) {
+			for _, arg := range args {
+				cond, err := condArg(ctx, arg)
+				if err != nil {
+					return 0, err
+				}
+
+				if !cond {
+					return 0, nil
+				}
+			}
+
+			return 1, nil
+		},
+		"or": func(ctx EvalContext, args []Expression) (float64, error) {
+			for _, arg := range args {
+				cond, err := condArg(ctx, arg)
+				if err != nil {
+					return 0, err
+				}
+
+				if cond {
+					return 1, nil
+				}
+			}
+
+			return 0, nil
+		},
+	}
 )
+
+// vectorArg evaluates an argument of the named function that must be a vector.
+func vectorArg(ctx EvalContext, name string, arg Expression) (Vector, error) {
+	val, err := arg.EvalValue(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	v, ok := val.(Vector)
+	if !ok {
+		return nil, fmt.Errorf("%s(): not a vector: %s", name, arg)
+	}
+
+	return v, nil
+}
+
+// mean adds up its args with the + operator, so that it averages the values
+// of evaluation modes as well, such as rationals, with the elements of vectors
+// taken as numbers.
+func mean(ctx EvalContext, args []Expression) (Value, error) {
+	var total Value
+	var count float64
+	add := func(arg Expression, val Value) error {
+		if total == nil {
+			total = val
+			return nil
+		}
+
+		sum, err := evalInfix("+", total, val)
+		if err != nil {
+			return fmt.Errorf("mean(): not a number or vector: %s", arg)
+		}
+
+		total = sum
+		return nil
+	}
+
+	for _, arg := range args {
+		val, err := arg.EvalValue(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		switch val := val.(type) {
+		case Vector:
+			for _, x := range val {
+				if err := add(arg, Float(x)); err != nil {
+					return nil, err
+				}
+			}
+			count += float64(len(val))
+		case Bool, String:
+			return nil, fmt.Errorf("mean(): not a number or vector: %s", arg)
+		default:
+			if err := add(arg, val); err != nil {
+				return nil, err
+			}
+			count++
+		}
+	}
+
+	if count == 0 {
+		return nil, fmt.Errorf("mean() requires at least 1 number")
+	}
+
+	n, err := numberValue(ctx, "", count)
+	if err != nil {
+		return nil, err
+	}
+
+	return evalInfix("/", total, n)
+}
+
+// condArg evaluates an argument used as a condition.
+func condArg(ctx EvalContext, arg Expression) (bool, error) {
+	val, err := arg.EvalValue(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return truthy(val)
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/xjem/calculon"
@@ -32,19 +34,41 @@ func main() {
 				return nil
 			}
 
-			if strings.Contains(input, "=") {
-				return repl.Define(input)
+			if arg := strings.TrimPrefix(input, ":precision"); arg != input {
+				prec, err := strconv.ParseUint(strings.TrimSpace(arg), 10, 32)
+				if err != nil || repl.SetPrecision(uint(prec)) != nil {
+					return fmt.Errorf("usage: :precision BITS, 0 for float64")
+				}
+
+				return nil
 			}
 
-			result, err := repl.Eval(input)
+			if arg := strings.TrimPrefix(input, ":d "); arg != input {
+				derivative, err := repl.Derive(strings.TrimSpace(arg))
+				if err != nil {
+					return err
+				}
+
+				fmt.Println(derivative)
+				return nil
+			}
+
+			result, ok, err := repl.Eval(input)
 			if err != nil {
 				return err
 			}
 
-			fmt.Println(result)
+			if ok {
+				fmt.Println(result)
+			}
 			return nil
 		}(strings.TrimSpace(input)); err != nil {
 			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+
+			var perr *calculon.ParseError
+			if errors.As(err, &perr) {
+				fmt.Fprintln(os.Stderr, perr.Snippet())
+			}
 		}
 	}
 }
@@ -0,0 +1,145 @@
+package calculon
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+const KindComplex Kind = "complex"
+
+// Complex is a complex number, written with an imaginary literal: 1 + 2i.
+// Arithmetic mixes complex numbers with real ones, and a complex number
+// with no imaginary part can be used as a real one.
+type Complex complex128
+
+func (c Complex) Kind() Kind { return KindComplex }
+
+func (c Complex) String() string {
+	re, im := real(c), imag(c)
+	switch {
+	case im == 0 && !math.Signbit(im):
+		return formatFloat(re)
+	case re == 0 && !math.Signbit(re):
+		return formatFloat(im) + "i"
+	case im < 0 || math.Signbit(im):
+		return formatFloat(re) + formatFloat(im) + "i"
+	default:
+		return formatFloat(re) + "+" + formatFloat(im) + "i"
+	}
+}
+
+func (c Complex) float() (float64, bool) {
+	return real(c), imag(c) == 0
+}
+
+// complexOps are the operators with a meaning of their own for complex numbers.
+// The others apply to complex numbers with no imaginary part as to real ones.
+var complexOps = map[string]func(l, r complex128) (Value, error){
+	"+":  func(l, r complex128) (Value, error) { return Complex(l + r), nil },
+	"-":  func(l, r complex128) (Value, error) { return Complex(l - r), nil },
+	"*":  func(l, r complex128) (Value, error) { return Complex(l * r), nil },
+	"^":  func(l, r complex128) (Value, error) { return Complex(cmplx.Pow(l, r)), nil },
+	"==": func(l, r complex128) (Value, error) { return Float(truth(l == r)), nil },
+	"!=": func(l, r complex128) (Value, error) { return Float(truth(l != r)), nil },
+	"/": func(l, r complex128) (Value, error) {
+		if r == 0 {
+			return nil, fmt.Errorf("divide by zero")
+		}
+
+		return Complex(l / r), nil
+	},
+}
+
+func init() {
+	for op, fn := range complexOps {
+		fn := fn
+		eval := func(l, r Value) (Value, error) {
+			return fn(toComplex(l), toComplex(r))
+		}
+
+		infixValueOps[infixKey{op: op, left: KindComplex, right: KindComplex}] = eval
+		infixValueOps[infixKey{op: op, left: KindComplex, right: KindNumber}] = eval
+		infixValueOps[infixKey{op: op, left: KindNumber, right: KindComplex}] = eval
+	}
+
+	// 0 - im rather than -im, so that -1 is not -1-0i, which lies on the other side
+	// of the branch cuts of sqrt and log than 0 - 1 does
+	unaryValueOps[unaryKey{op: "-", kind: KindComplex}] = func(x Value) (Value, error) {
+		c := x.(Complex)
+		return Complex(complex(-real(c), 0-imag(c))), nil
+	}
+	unaryValueOps[unaryKey{op: "+", kind: KindComplex}] = func(x Value) (Value, error) { return x, nil }
+}
+
+// toComplex converts a number or a complex number to complex128.
+func toComplex(val Value) complex128 {
+	if c, ok := val.(Complex); ok {
+		return complex128(c)
+	}
+
+	f, _ := realValue(val)
+	return complex(float64(f), 0)
+}
+
+// complexFunc makes a function of one number, real or complex.
+func complexFunc(name string, fn func(x complex128) Value) LazyValueFunction {
+	return eagerValue(complexValueFunc(name, fn))
+}
+
+func complexValueFunc(name string, fn func(x complex128) Value) ValueFunction {
+	return func(args []Value) (Value, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s() requires 1 arg", name)
+		}
+
+		if _, ok := args[0].(Complex); !ok {
+			if _, ok := realValue(args[0]); !ok {
+				return nil, fmt.Errorf("%s(): not a number: %s", name, args[0])
+			}
+		}
+
+		return fn(toComplex(args[0])), nil
+	}
+}
+
+// abs is the absolute value of a real number, or the modulus of a complex one.
+var abs = complexValueFunc("abs", func(x complex128) Value { return Float(cmplx.Abs(x)) })
+
+// complexModeFuncs replace the builtins of the same name in ComplexMode.
+var complexModeFuncs = map[string]LazyValueFunction{
+	"sin":  complexFunc("sin", func(x complex128) Value { return Complex(cmplx.Sin(x)) }),
+	"cos":  complexFunc("cos", func(x complex128) Value { return Complex(cmplx.Cos(x)) }),
+	"exp":  complexFunc("exp", func(x complex128) Value { return Complex(cmplx.Exp(x)) }),
+	"log":  complexFunc("log", func(x complex128) Value { return Complex(cmplx.Log(x)) }),
+	"sqrt": complexFunc("sqrt", func(x complex128) Value { return Complex(cmplx.Sqrt(x)) }),
+}
+
+// ComplexMode evaluates the numbers of ctx as complex ones, so that operators
+// leave the real line rather than giving NaN: (-8)^(1/3) is about 1+1.732i.
+// The functions sin, cos, exp, log and sqrt take and return complex numbers,
+// they replace any functions of these names in ctx.
+func ComplexMode(ctx EvalContext) EvalContext {
+	return &modeContext{
+		parent: ctx,
+		number: func(literal string, value float64) (Value, error) {
+			return Complex(complex(value, 0)), nil
+		},
+		funcs: complexModeFuncs,
+	}
+}
+
+// EvalComplex evaluates expr in ComplexMode(ctx).
+func EvalComplex(expr Expression, ctx EvalContext) (complex128, error) {
+	val, err := expr.EvalValue(ComplexMode(ctx))
+	if err != nil {
+		return 0, err
+	}
+
+	switch val.(type) {
+	case Float, Complex:
+		return toComplex(val), nil
+	default:
+		return 0, fmt.Errorf("%s used as a complex number: %s", val.Kind(), val)
+	}
+}
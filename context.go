@@ -1,47 +1,279 @@
 package calculon
 
+import "strings"
+
 type EvalContext interface {
 	LookupVar(name string) (float64, bool)
 	LookupFunc(name string) (Function, bool)
 }
 
+// NamespaceContext is implemented by contexts that group names into namespaces.
+// A qualified name such as stats.mean is looked up as mean in the namespace stats.
+type NamespaceContext interface {
+	EvalContext
+	LookupNamespace(name string) (EvalContext, bool)
+}
+
+// LazyContext is implemented by contexts that provide lazy functions.
+type LazyContext interface {
+	EvalContext
+	LookupLazyFunc(name string) (LazyFunction, bool)
+}
+
+// ValueContext is implemented by contexts whose variables and functions
+// may hold and return values other than numbers, such as vectors.
+type ValueContext interface {
+	EvalContext
+	LookupValue(name string) (Value, bool)
+	LookupValueFunc(name string) (LazyValueFunction, bool)
+}
+
+// ArityContext is implemented by contexts that know how many arguments
+// their functions take, see Check.
+type ArityContext interface {
+	EvalContext
+	LookupArity(name string) (Arity, bool)
+}
+
+// MutableContext is implemented by contexts that programs can define names in,
+// see Program.Run.
+type MutableContext interface {
+	EvalContext
+	SetVar(name string, value float64)
+	SetValue(name string, value Value)
+	SetFunc(name string, fn Function)
+	SetLazyValueFunc(name string, fn LazyValueFunction)
+}
+
 type EmptyContext struct{}
 
 func (EmptyContext) LookupVar(name string) (float64, bool) { return 0, false }
 
 func (EmptyContext) LookupFunc(name string) (Function, bool) { return nil, false }
 
-// Context contains user-defined variables and functions.
+// Context contains user-defined variables, functions and namespaces.
 type Context struct {
-	vars  map[string]float64
-	funcs map[string]Function
+	vars       map[string]float64
+	values     map[string]Value // variables that are not numbers
+	funcs      map[string]Function
+	lazyFuncs  map[string]LazyFunction
+	valueFuncs map[string]LazyValueFunction
+	namespaces map[string]EvalContext
+	arities    map[string]Arity
+
+	maxCallDepth int // 0 for MaxCallDepth
 }
 
 func NewContext() *Context {
 	return &Context{
-		vars:  make(map[string]float64),
-		funcs: make(map[string]Function),
+		vars:       make(map[string]float64),
+		values:     make(map[string]Value),
+		funcs:      make(map[string]Function),
+		lazyFuncs:  make(map[string]LazyFunction),
+		valueFuncs: make(map[string]LazyValueFunction),
+		namespaces: make(map[string]EvalContext),
+		arities:    make(map[string]Arity),
 	}
 }
 
+// SetMaxCallDepth limits how deep calls of user functions may nest when
+// evaluating in ctx, in place of MaxCallDepth.
+func (ctx *Context) SetMaxCallDepth(depth int) {
+	ctx.maxCallDepth = depth
+}
+
 func (ctx *Context) SetVar(name string, value float64) {
+	delete(ctx.values, name)
 	ctx.vars[name] = value
 }
 
+// SetValue sets a variable to any value, such as a Vector.
+func (ctx *Context) SetValue(name string, value Value) {
+	if f, ok := value.(Float); ok {
+		ctx.SetVar(name, float64(f))
+		return
+	}
+
+	delete(ctx.vars, name)
+	ctx.values[name] = value
+}
+
+// SetFunc defines a function, replacing any other kind of function of the same name.
 func (ctx *Context) SetFunc(name string, fn Function) {
+	ctx.undefineFunc(name)
 	ctx.funcs[name] = fn
 }
 
+// SetLazyFunc defines a function that receives its arguments unevaluated.
+func (ctx *Context) SetLazyFunc(name string, fn LazyFunction) {
+	ctx.undefineFunc(name)
+	ctx.lazyFuncs[name] = fn
+}
+
+// SetValueFunc defines a function taking and returning values of any kind.
+func (ctx *Context) SetValueFunc(name string, fn ValueFunction) {
+	ctx.SetLazyValueFunc(name, eagerValue(fn))
+}
+
+// SetLazyValueFunc defines a function that receives its arguments unevaluated
+// and returns values of any kind.
+func (ctx *Context) SetLazyValueFunc(name string, fn LazyValueFunction) {
+	ctx.undefineFunc(name)
+	ctx.valueFuncs[name] = fn
+}
+
+// SetArity declares how many arguments the function name takes, see Check.
+// Defining the function anew forgets it.
+func (ctx *Context) SetArity(name string, arity Arity) {
+	ctx.arities[name] = arity
+}
+
+func (ctx *Context) undefineFunc(name string) {
+	delete(ctx.arities, name)
+	delete(ctx.funcs, name)
+	delete(ctx.lazyFuncs, name)
+	delete(ctx.valueFuncs, name)
+}
+
+// SetNamespace makes the names of ns available as name.x.
+func (ctx *Context) SetNamespace(name string, ns EvalContext) {
+	ctx.namespaces[name] = ns
+}
+
 func (ctx *Context) LookupVar(name string) (float64, bool) {
 	val, found := ctx.vars[name]
 	return val, found
 }
 
+func (ctx *Context) LookupValue(name string) (Value, bool) {
+	if val, found := ctx.values[name]; found {
+		return val, true
+	}
+
+	f, found := ctx.vars[name]
+	return Float(f), found
+}
+
 func (ctx *Context) LookupFunc(name string) (Function, bool) {
 	fn, found := ctx.funcs[name]
 	return fn, found
 }
 
+func (ctx *Context) LookupLazyFunc(name string) (LazyFunction, bool) {
+	fn, found := ctx.lazyFuncs[name]
+	return fn, found
+}
+
+func (ctx *Context) LookupValueFunc(name string) (LazyValueFunction, bool) {
+	fn, found := ctx.valueFuncs[name]
+	return fn, found
+}
+
+func (ctx *Context) LookupArity(name string) (Arity, bool) {
+	arity, found := ctx.arities[name]
+	return arity, found
+}
+
+func (ctx *Context) LookupNamespace(name string) (EvalContext, bool) {
+	ns, found := ctx.namespaces[name]
+	return ns, found
+}
+
+// lookupVar looks up a variable, resolving namespaces of qualified names.
+func lookupVar(ctx EvalContext, name string) (float64, bool) {
+	if ns, local, found := resolveNamespace(ctx, name); found {
+		return lookupVar(ns, local)
+	}
+
+	return ctx.LookupVar(name)
+}
+
+// lookupValue looks up a variable of any value, resolving namespaces of qualified names.
+func lookupValue(ctx EvalContext, name string) (Value, bool) {
+	if ns, local, found := resolveNamespace(ctx, name); found {
+		return lookupValue(ns, local)
+	}
+
+	if valctx, ok := ctx.(ValueContext); ok {
+		return valctx.LookupValue(name)
+	}
+
+	f, found := ctx.LookupVar(name)
+	return Float(f), found
+}
+
+// lookupFunc looks up a function, resolving namespaces of qualified names.
+func lookupFunc(ctx EvalContext, name string) (Function, bool) {
+	if ns, local, found := resolveNamespace(ctx, name); found {
+		return lookupFunc(ns, local)
+	}
+
+	return ctx.LookupFunc(name)
+}
+
+// lookupLazyFunc looks up a lazy function, resolving namespaces of qualified names.
+func lookupLazyFunc(ctx EvalContext, name string) (LazyFunction, bool) {
+	if ns, local, found := resolveNamespace(ctx, name); found {
+		return lookupLazyFunc(ns, local)
+	}
+
+	lazyctx, ok := ctx.(LazyContext)
+	if !ok {
+		return nil, false
+	}
+
+	return lazyctx.LookupLazyFunc(name)
+}
+
+// lookupValueFunc looks up a value function, resolving namespaces of qualified names.
+func lookupValueFunc(ctx EvalContext, name string) (LazyValueFunction, bool) {
+	if ns, local, found := resolveNamespace(ctx, name); found {
+		return lookupValueFunc(ns, local)
+	}
+
+	valctx, ok := ctx.(ValueContext)
+	if !ok {
+		return nil, false
+	}
+
+	return valctx.LookupValueFunc(name)
+}
+
+// lookupArity looks up the arity of a function, resolving namespaces of qualified names.
+func lookupArity(ctx EvalContext, name string) (Arity, bool) {
+	if ns, local, found := resolveNamespace(ctx, name); found {
+		return lookupArity(ns, local)
+	}
+
+	arityctx, ok := ctx.(ArityContext)
+	if !ok {
+		return Arity{}, false
+	}
+
+	return arityctx.LookupArity(name)
+}
+
+// resolveNamespace splits ns.local and looks up the namespace ns in ctx.
+// Names whose namespace is unknown are left to the context as they are.
+func resolveNamespace(ctx EvalContext, name string) (EvalContext, string, bool) {
+	dot := strings.IndexByte(name, '.')
+	if dot < 0 {
+		return nil, "", false
+	}
+
+	nsctx, ok := ctx.(NamespaceContext)
+	if !ok {
+		return nil, "", false
+	}
+
+	ns, found := nsctx.LookupNamespace(name[:dot])
+	if !found {
+		return nil, "", false
+	}
+
+	return ns, name[dot+1:], true
+}
+
 func MathContext() *Context {
 	ctx := NewContext()
 	for name, val := range builtinVars {
@@ -52,5 +284,17 @@ func MathContext() *Context {
 		ctx.funcs[name] = fn
 	}
 
+	for name, fn := range builtinLazyFuncs {
+		ctx.lazyFuncs[name] = fn
+	}
+
+	for name, fn := range builtinValueFuncs {
+		ctx.valueFuncs[name] = fn
+	}
+
+	for name, arity := range builtinArities {
+		ctx.arities[name] = arity
+	}
+
 	return ctx
 }
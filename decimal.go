@@ -0,0 +1,358 @@
+package calculon
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const KindDecimal Kind = "decimal"
+
+// Rounding tells how decimals are rounded to their scale.
+type Rounding int
+
+const (
+	RoundHalfEven Rounding = iota // to the nearest, ties to even digits: 0.125 is 0.12
+	RoundHalfUp                   // to the nearest, ties away from zero: 0.125 is 0.13
+	RoundTruncate                 // towards zero: 0.129 is 0.12
+)
+
+// Decimal is a fixed-point decimal number with a number of digits after the point,
+// its scale, see DecimalMode. Results of operators on decimals are rounded
+// to the scale the decimals were made with. The zero Decimal is 0 with no digits
+// after the point.
+type Decimal struct {
+	unscaled *big.Int
+	scale    int
+	// the scale and rounding of results of operators
+	opScale  int
+	rounding Rounding
+}
+
+// NewDecimal rounds r to scale digits after the point. Results of operators
+// on the decimal are rounded the same way.
+func NewDecimal(r *big.Rat, scale int, rounding Rounding) Decimal {
+	if scale < 0 {
+		panic("calculon: negative decimal scale")
+	}
+
+	return Decimal{unscaled: roundRat(r, scale, rounding), scale: scale, opScale: scale, rounding: rounding}
+}
+
+// exactDecimal is r with as many digits after the point as it takes, and at least scale.
+// Results of operators on it are rounded to scale. It fails if r has no finite
+// decimal form, as 1/3 does not.
+func exactDecimal(r *big.Rat, scale int, rounding Rounding) (Decimal, bool) {
+	// r has n digits after the point if its denominator is 2^a * 5^b with a, b <= n
+	den := new(big.Int).Set(r.Denom())
+	digits := 0
+	for _, p := range []int64{2, 5} {
+		var n int
+		for rem := new(big.Int); ; n++ {
+			q, _ := new(big.Int).QuoRem(den, big.NewInt(p), rem)
+			if rem.Sign() != 0 {
+				break
+			}
+
+			den = q
+		}
+
+		if n > digits {
+			digits = n
+		}
+	}
+
+	if den.Cmp(big.NewInt(1)) != 0 {
+		return Decimal{}, false
+	}
+
+	if digits < scale {
+		digits = scale
+	}
+
+	d := NewDecimal(r, digits, rounding)
+	d.opScale = scale
+	return d, true
+}
+
+func (d Decimal) Kind() Kind { return KindDecimal }
+
+func (d Decimal) String() string {
+	digits := new(big.Int).Abs(d.digits()).String()
+	if len(digits) <= d.scale {
+		digits = strings.Repeat("0", d.scale-len(digits)+1) + digits
+	}
+
+	sign := ""
+	if d.digits().Sign() < 0 {
+		sign = "-"
+	}
+
+	if d.scale == 0 {
+		return sign + digits
+	}
+
+	point := len(digits) - d.scale
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+// Unscaled returns the digits of d as an integer, d is Unscaled / 10^Scale.
+func (d Decimal) Unscaled() *big.Int { return new(big.Int).Set(d.digits()) }
+
+func (d Decimal) Scale() int { return d.scale }
+
+// Rat returns d as a fraction.
+func (d Decimal) Rat() *big.Rat {
+	return new(big.Rat).SetFrac(d.digits(), pow10(d.scale))
+}
+
+func (d Decimal) digits() *big.Int {
+	if d.unscaled == nil {
+		return new(big.Int)
+	}
+
+	return d.unscaled
+}
+
+func (d Decimal) float() (float64, bool) {
+	f, _ := d.Rat().Float64()
+	return f, true
+}
+
+// with returns r rounded like the results of operators on d.
+func (d Decimal) with(r *big.Rat) Decimal {
+	return NewDecimal(r, d.opScale, d.rounding)
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// roundRat returns r * 10^scale rounded to an integer.
+func roundRat(r *big.Rat, scale int, rounding Rounding) *big.Int {
+	num := new(big.Int).Mul(r.Num(), pow10(scale))
+	q, rem := new(big.Int).QuoRem(num, r.Denom(), new(big.Int))
+	if rem.Sign() == 0 || rounding == RoundTruncate {
+		return q
+	}
+
+	// compare the dropped fraction with one half
+	half := new(big.Int).Abs(rem)
+	half.Lsh(half, 1)
+	switch cmp := half.Cmp(r.Denom()); {
+	case cmp > 0, cmp == 0 && rounding == RoundHalfUp, cmp == 0 && q.Bit(0) == 1:
+		return q.Add(q, big.NewInt(int64(r.Sign())))
+	}
+
+	return q
+}
+
+// decimalResult rounds the result of a rational operator to the scale of d.
+// Floats, which the operators give for inexact powers, are rounded as well,
+// unless they are NaN or infinite.
+func decimalResult(d Decimal, val Value) Value {
+	switch val := val.(type) {
+	case Rational:
+		return d.with(val.Rat)
+	case Float:
+		if r, ok := parseRational("", float64(val)); ok {
+			return d.with(r)
+		}
+	}
+
+	return val
+}
+
+func init() {
+	// decimals are computed exactly as fractions, then rounded
+	for op, fn := range rationalOps {
+		op, fn := op, fn
+		eval := func(l, r Value) (Value, error) {
+			d, ok := l.(Decimal)
+			if rd, rok := r.(Decimal); rok && (!ok || rd.opScale > d.opScale) {
+				d = rd
+			}
+
+			lr, lok := decimalRat(l)
+			rr, rok := decimalRat(r)
+			if !lok || !rok {
+				// NaN and infinities have no decimal
+				left, _ := realValue(l)
+				right, _ := realValue(r)
+				return mapBinary(infixOps[op].eval, left, right)
+			}
+
+			val, err := fn(lr, rr)
+			if err != nil {
+				return nil, err
+			}
+
+			return decimalResult(d, val), nil
+		}
+
+		infixValueOps[infixKey{op: op, left: KindDecimal, right: KindDecimal}] = eval
+		infixValueOps[infixKey{op: op, left: KindDecimal, right: KindNumber}] = eval
+		infixValueOps[infixKey{op: op, left: KindNumber, right: KindDecimal}] = eval
+	}
+
+	unaryValueOps[unaryKey{op: "-", kind: KindDecimal}] = func(x Value) (Value, error) {
+		d := x.(Decimal)
+		d.unscaled = new(big.Int).Neg(d.digits())
+		return d, nil
+	}
+	unaryValueOps[unaryKey{op: "+", kind: KindDecimal}] = func(x Value) (Value, error) { return x, nil }
+	unaryValueOps[unaryKey{op: "!", kind: KindDecimal}] = func(x Value) (Value, error) {
+		d := x.(Decimal)
+		return d.with(big.NewRat(int64(truth(d.digits().Sign() == 0)), 1)), nil
+	}
+	unaryValueOps[unaryKey{op: "%", postfix: true, kind: KindDecimal}] = func(x Value) (Value, error) {
+		d := x.(Decimal)
+		return d.with(new(big.Rat).Quo(d.Rat(), big.NewRat(100, 1))), nil
+	}
+}
+
+// decimalRat converts a decimal or a float to a fraction, floats through
+// their shortest decimal form. It fails for NaN and infinities.
+func decimalRat(val Value) (*big.Rat, bool) {
+	switch val := val.(type) {
+	case Decimal:
+		return val.Rat(), true
+	case Float:
+		return parseRational("", float64(val))
+	default:
+		return nil, false
+	}
+}
+
+// roundFloat rounds x to n digits after the point, ties to even.
+func roundFloat(x float64, n int) float64 {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return x
+	}
+
+	f, _ := strconv.ParseFloat(strconv.FormatFloat(x, 'f', n, 64), 64)
+	return f
+}
+
+// roundArgs checks the arguments of round(x) and round(x, n).
+func roundArgs(args []Value) (int, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return 0, fmt.Errorf("round() requires 1 or 2 args")
+	}
+
+	if len(args) == 1 {
+		return 0, nil
+	}
+
+	n, ok := realValue(args[1])
+	if !ok || n != Float(math.Trunc(float64(n))) || n < 0 || n > 1000 {
+		return 0, fmt.Errorf("round(): digits must be an integer from 0 to 1000: %s", args[1])
+	}
+
+	return int(n), nil
+}
+
+// decimalModeFuncs replace the builtins of the same name in DecimalMode.
+var decimalModeFuncs = map[string]LazyValueFunction{
+	// round rounds to n digits after the point with the rounding of the mode
+	"round": eagerValue(func(args []Value) (Value, error) {
+		n, err := roundArgs(args)
+		if err != nil {
+			return nil, err
+		}
+
+		d, ok := args[0].(Decimal)
+		if !ok {
+			return builtinRound(args)
+		}
+
+		if n >= d.scale {
+			return d, nil
+		}
+
+		rounded := NewDecimal(d.Rat(), n, d.rounding)
+		if n < d.opScale {
+			return d.with(rounded.Rat()), nil
+		}
+
+		rounded.opScale = d.opScale
+		return rounded, nil
+	}),
+	"abs": eagerValue(func(args []Value) (Value, error) {
+		if len(args) == 1 {
+			if d, ok := args[0].(Decimal); ok {
+				d.unscaled = new(big.Int).Abs(d.digits())
+				return d, nil
+			}
+		}
+
+		return abs(args)
+	}),
+}
+
+// builtinRound is round for floats, ties go to even digits.
+func builtinRound(args []Value) (Value, error) {
+	n, err := roundArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	x, ok := realValue(args[0])
+	if !ok {
+		return nil, fmt.Errorf("round(): not a number: %s", args[0])
+	}
+
+	return Float(roundFloat(float64(x), n)), nil
+}
+
+// DecimalMode evaluates the numbers of ctx as decimals with scale digits after
+// the point, so that 0.1 * 3 is exactly 0.3. Number literals are read exactly
+// and keep all their digits, a rate of 0.0725 is not rounded to two digits,
+// but the result of every operator is rounded to the scale with the given rounding.
+// round(x, n) rounds to fewer digits the same way. Other functions compute
+// with float64 numbers, their results are rounded when they meet decimals.
+// Numeric variables are read through their shortest decimal form.
+func DecimalMode(ctx EvalContext, scale int, rounding Rounding) EvalContext {
+	if scale < 0 {
+		panic("calculon: negative decimal scale")
+	}
+
+	return &modeContext{
+		parent: ctx,
+		number: func(literal string, value float64) (Value, error) {
+			if r, ok := parseRational(literal, value); ok {
+				if d, ok := exactDecimal(r, scale, rounding); ok {
+					return d, nil
+				}
+			}
+
+			return Float(value), nil
+		},
+		funcs: decimalModeFuncs,
+	}
+}
+
+// EvalDecimal evaluates expr in DecimalMode(ctx, scale, rounding),
+// and rounds the result to the scale.
+func EvalDecimal(expr Expression, ctx EvalContext, scale int, rounding Rounding) (Decimal, error) {
+	val, err := expr.EvalValue(DecimalMode(ctx, scale, rounding))
+	if err != nil {
+		return Decimal{}, err
+	}
+
+	switch val := val.(type) {
+	case Decimal:
+		return NewDecimal(val.Rat(), scale, rounding), nil
+	case Float:
+		r, ok := parseRational("", float64(val))
+		if !ok {
+			return Decimal{}, fmt.Errorf("result is not a decimal number: %s", val)
+		}
+
+		return NewDecimal(r, scale, rounding), nil
+	default:
+		return Decimal{}, fmt.Errorf("%s used as a decimal number: %s", val.Kind(), val)
+	}
+}
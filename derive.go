@@ -0,0 +1,194 @@
+package calculon
+
+import (
+	"fmt"
+)
+
+// SymbolicDerivative returns the partial derivatives of a function with respect
+// to each of its arguments, as expressions of the arguments, see RegisterSymbolicDerivative.
+type SymbolicDerivative = func(args []Expression) ([]Expression, error)
+
+// symbolicUnary makes the derivative of a function of one argument.
+func symbolicUnary(name string, fn func(u Expression) Expression) SymbolicDerivative {
+	return func(args []Expression) ([]Expression, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s() requires 1 arg", name)
+		}
+
+		return []Expression{fn(args[0])}, nil
+	}
+}
+
+func call(name string, args ...Expression) Expression {
+	return FunctionCall{Name: name, Args: args}
+}
+
+// symbolicDerivatives holds the derivatives used by Derive. Besides the builtins
+// they cover exp, log and sqrt, with their usual meanings.
+var symbolicDerivatives = map[string]SymbolicDerivative{
+	"sin": symbolicUnary("sin", func(u Expression) Expression { return call("cos", u) }),
+	"cos": symbolicUnary("cos", func(u Expression) Expression { return algebra.neg(call("sin", u)) }),
+	"abs": symbolicUnary("abs", func(u Expression) Expression { return algebra.div(u, call("abs", u)) }),
+	"exp": symbolicUnary("exp", func(u Expression) Expression { return call("exp", u) }),
+	"log": symbolicUnary("log", func(u Expression) Expression { return algebra.div(num(1), u) }),
+	"sqrt": symbolicUnary("sqrt", func(u Expression) Expression {
+		return algebra.div(num(1), algebra.mul(num(2), call("sqrt", u)))
+	}),
+}
+
+// RegisterSymbolicDerivative defines the derivative of the named function for Derive:
+//
+//	RegisterSymbolicDerivative("tan", func(args []Expression) ([]Expression, error) {
+//		return []Expression{BinaryOp{Op: "/", Left: Number{Value: 1}, Right: BinaryOp{
+//			Op: "^", Left: FunctionCall{Name: "cos", Args: args}, Right: Number{Value: 2}}}}, nil
+//	})
+//
+// Registration is not safe for concurrent use with differentiation.
+func RegisterSymbolicDerivative(name string, fn SymbolicDerivative) {
+	symbolicDerivatives[name] = fn
+}
+
+// Derive differentiates expr with respect to variable by the rules of calculus,
+// and returns the derivative simplified, with terms such as 0 * x and x^1 folded:
+// the derivative of sin(x) * 2 is cos(x) * 2.
+//
+// Functions need a derivative, see RegisterSymbolicDerivative. Comparisons,
+// and other operators that are constant where they are differentiable, have
+// the derivative 0. Expressions defining names, such as let, are not differentiated.
+func Derive(expr Expression, variable string) (Expression, error) {
+	switch expr := expr.(type) {
+	case Number, Imaginary:
+		return num(0), nil
+	case Variable:
+		if expr.Name == variable {
+			return num(1), nil
+		}
+
+		return num(0), nil
+	case Parentheses:
+		return Derive(expr.Expr, variable)
+	case BinaryOp:
+		return deriveBinary(expr, variable)
+	case UnaryOp:
+		du, err := Derive(expr.Expr, variable)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case expr.Op == "-" && !expr.IsPostfix:
+			return algebra.neg(du), nil
+		case expr.Op == "+" && !expr.IsPostfix:
+			return du, nil
+		case expr.Op == "%" && expr.IsPostfix:
+			return algebra.div(du, num(100)), nil
+		case expr.Op == "!" && !expr.IsPostfix:
+			return num(0), nil
+		}
+	case Conditional:
+		dthen, err := Derive(expr.Then, variable)
+		if err != nil {
+			return nil, err
+		}
+
+		delse, err := Derive(expr.Else, variable)
+		if err != nil {
+			return nil, err
+		}
+
+		return Conditional{Cond: expr.Cond, Then: dthen, Else: delse}, nil
+	case FunctionCall:
+		return deriveCall(expr, variable)
+	}
+
+	return nil, fmt.Errorf("cannot differentiate %s", expr)
+}
+
+func deriveBinary(expr BinaryOp, variable string) (Expression, error) {
+	u, v := unparen(expr.Left), unparen(expr.Right)
+	du, err := Derive(u, variable)
+	if err != nil {
+		return nil, err
+	}
+
+	dv, err := Derive(v, variable)
+	if err != nil {
+		return nil, err
+	}
+
+	switch expr.Op {
+	case "+":
+		return algebra.add(du, dv), nil
+	case "-":
+		return algebra.sub(du, dv), nil
+	case "*":
+		return algebra.add(algebra.mul(du, v), algebra.mul(u, dv)), nil
+	case "/":
+		return algebra.div(algebra.sub(algebra.mul(du, v), algebra.mul(u, dv)), algebra.pow(v, num(2))), nil
+	case "^":
+		if isZero(dv) {
+			// power rule: n * u^(n-1) * u'
+			return algebra.mul(algebra.mul(v, algebra.pow(u, algebra.sub(v, num(1)))), du), nil
+		}
+
+		// u^v * (v' * log(u) + v * u' / u), where u' = 0 leaves u^v * log(u) * v'
+		return algebra.mul(algebra.pow(u, v), algebra.add(algebra.mul(dv, logOf(u)), algebra.div(algebra.mul(v, du), u))), nil
+	case "%":
+		if isZero(dv) {
+			return du, nil
+		}
+	case "==", "!=", "<", "<=", ">", ">=", "&&", "||", "xor":
+		return num(0), nil
+	}
+
+	return nil, fmt.Errorf("cannot differentiate %s", expr)
+}
+
+// deriveCall applies the chain rule to a function call.
+func deriveCall(expr FunctionCall, variable string) (Expression, error) {
+	fn, found := symbolicDerivatives[expr.Name]
+	if !found {
+		return nil, fmt.Errorf("cannot differentiate %s: no derivative of %s()", expr, expr.Name)
+	}
+
+	args := make([]Expression, len(expr.Args))
+	for i, arg := range expr.Args {
+		args[i] = unparen(arg)
+	}
+
+	partials, err := fn(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(partials) != len(expr.Args) {
+		return nil, fmt.Errorf("%s(): got %d partial derivatives for %d args", expr.Name, len(partials), len(expr.Args))
+	}
+
+	var result Expression = num(0)
+	for i, arg := range args {
+		darg, err := Derive(arg, variable)
+		if err != nil {
+			return nil, err
+		}
+
+		if !isZero(darg) {
+			result = algebra.add(result, algebra.mul(partials[i], darg))
+		}
+	}
+
+	return result, nil
+}
+
+// algebra simplifies derivatives as they are built. Its rewrites may turn
+// NaN into numbers, as 0 * x does, where a constant has the derivative 0.
+var algebra = simplifier{opts: SimplifyOptions{IgnoreNaN: true}}
+
+// logOf is the natural logarithm of u, which is 1 for E.
+func logOf(u Expression) Expression {
+	if v, ok := unparen(u).(Variable); ok && v.Name == "E" {
+		return num(1)
+	}
+
+	return call("log", u)
+}
@@ -0,0 +1,370 @@
+package calculon
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+const KindDual Kind = "dual"
+
+// Dual is a number with its gradient, the partial derivatives with respect
+// to the variables given to EvalGrad. Operators on duals apply the chain rule.
+type Dual struct {
+	Val  float64
+	Grad []float64
+}
+
+func (d Dual) Kind() Kind { return KindDual }
+
+func (d Dual) String() string {
+	return "dual(" + formatFloat(d.Val) + ", " + Vector(d.Grad).String() + ")"
+}
+
+// toDual converts a dual or a number, numbers have a zero gradient of n elements.
+func toDual(val Value, n int) Dual {
+	if d, ok := val.(Dual); ok {
+		return d
+	}
+
+	f, _ := realValue(val)
+	return Dual{Val: float64(f), Grad: make([]float64, n)}
+}
+
+// dualBinary converts the operands of a binary operator, at least one of them is a dual.
+func dualBinary(l, r Value) (Dual, Dual) {
+	n := 0
+	for _, val := range []Value{l, r} {
+		if d, ok := val.(Dual); ok {
+			n = len(d.Grad)
+		}
+	}
+
+	return toDual(l, n), toDual(r, n)
+}
+
+// chain combines the gradients of l and r weighted by the partial derivatives dl and dr.
+// Terms of zero gradients are left out, their partial derivatives may be infinite.
+func chain(l Dual, dl float64, r Dual, dr float64) []float64 {
+	grad := make([]float64, len(l.Grad))
+	for i := range grad {
+		if l.Grad[i] != 0 {
+			grad[i] += dl * l.Grad[i]
+		}
+
+		if r.Grad[i] != 0 {
+			grad[i] += dr * r.Grad[i]
+		}
+	}
+
+	return grad
+}
+
+var dualOps = map[string]func(l, r Dual) (Value, error){
+	"+": func(l, r Dual) (Value, error) { return Dual{l.Val + r.Val, chain(l, 1, r, 1)}, nil },
+	"-": func(l, r Dual) (Value, error) { return Dual{l.Val - r.Val, chain(l, 1, r, -1)}, nil },
+	"*": func(l, r Dual) (Value, error) { return Dual{l.Val * r.Val, chain(l, r.Val, r, l.Val)}, nil },
+	"/": func(l, r Dual) (Value, error) {
+		if r.Val == 0 {
+			return nil, fmt.Errorf("divide by zero")
+		}
+
+		return Dual{l.Val / r.Val, chain(l, 1/r.Val, r, -l.Val/(r.Val*r.Val))}, nil
+	},
+	// remainder of the division truncated towards zero, like math.Mod
+	"%": func(l, r Dual) (Value, error) {
+		return Dual{math.Mod(l.Val, r.Val), chain(l, 1, r, -math.Trunc(l.Val/r.Val))}, nil
+	},
+	"^": func(l, r Dual) (Value, error) {
+		pow := math.Pow(l.Val, r.Val)
+		return Dual{pow, chain(l, r.Val*math.Pow(l.Val, r.Val-1), r, pow*math.Log(l.Val))}, nil
+	},
+	// comparisons and logic are constant where they are differentiable
+	"==":  func(l, r Dual) (Value, error) { return Float(truth(l.Val == r.Val)), nil },
+	"!=":  func(l, r Dual) (Value, error) { return Float(truth(l.Val != r.Val)), nil },
+	"<":   func(l, r Dual) (Value, error) { return Float(truth(l.Val < r.Val)), nil },
+	"<=":  func(l, r Dual) (Value, error) { return Float(truth(l.Val <= r.Val)), nil },
+	">":   func(l, r Dual) (Value, error) { return Float(truth(l.Val > r.Val)), nil },
+	">=":  func(l, r Dual) (Value, error) { return Float(truth(l.Val >= r.Val)), nil },
+	"&&":  func(l, r Dual) (Value, error) { return Float(truth(l.Val != 0 && r.Val != 0)), nil },
+	"||":  func(l, r Dual) (Value, error) { return Float(truth(l.Val != 0 || r.Val != 0)), nil },
+	"xor": func(l, r Dual) (Value, error) { return Float(truth((l.Val != 0) != (r.Val != 0))), nil },
+}
+
+func init() {
+	for op, fn := range dualOps {
+		fn := fn
+		eval := func(l, r Value) (Value, error) {
+			return fn(dualBinary(l, r))
+		}
+
+		infixValueOps[infixKey{op: op, left: KindDual, right: KindDual}] = eval
+		infixValueOps[infixKey{op: op, left: KindDual, right: KindNumber}] = eval
+		infixValueOps[infixKey{op: op, left: KindNumber, right: KindDual}] = eval
+	}
+
+	unaryValueOps[unaryKey{op: "-", kind: KindDual}] = func(x Value) (Value, error) {
+		d := x.(Dual)
+		return Dual{-d.Val, scaleGrad(d.Grad, -1)}, nil
+	}
+	unaryValueOps[unaryKey{op: "+", kind: KindDual}] = func(x Value) (Value, error) { return x, nil }
+	unaryValueOps[unaryKey{op: "!", kind: KindDual}] = func(x Value) (Value, error) {
+		return Float(truth(x.(Dual).Val == 0)), nil
+	}
+	unaryValueOps[unaryKey{op: "%", postfix: true, kind: KindDual}] = func(x Value) (Value, error) {
+		d := x.(Dual)
+		return Dual{d.Val / 100, scaleGrad(d.Grad, 0.01)}, nil
+	}
+}
+
+func scaleGrad(grad []float64, k float64) []float64 {
+	scaled := make([]float64, len(grad))
+	for i, g := range grad {
+		scaled[i] = k * g
+	}
+
+	return scaled
+}
+
+// derivatives holds the partial derivatives of functions, by argument.
+var derivatives = map[string][]Function{
+	"sin": {func(args []float64) (float64, error) { return math.Cos(args[0]), nil }},
+	"cos": {func(args []float64) (float64, error) { return -math.Sin(args[0]), nil }},
+}
+
+// RegisterDerivative defines the partial derivatives of the function of numbers
+// with the given name, one for each argument, for EvalGrad:
+//
+//	RegisterDerivative("sin", func(args []float64) (float64, error) { return math.Cos(args[0]), nil })
+//
+// Functions without derivatives are differentiated numerically. Registration
+// is not safe for concurrent use with evaluation.
+func RegisterDerivative(name string, partials ...Function) {
+	derivatives[name] = partials
+}
+
+// dualFunc makes fn take duals, its derivatives are the given partials, or central
+// finite differences for arguments without one.
+func dualFunc(name string, fn Function, partials []Function) LazyValueFunction {
+	return eagerValue(func(args []Value) (Value, error) {
+		x := make([]float64, len(args))
+		var duals []int
+		for i, arg := range args {
+			if d, ok := arg.(Dual); ok {
+				x[i] = d.Val
+				duals = append(duals, i)
+				continue
+			}
+
+			f, ok := realValue(arg)
+			if !ok {
+				return nil, fmt.Errorf("%s(): not a number: %s", name, arg)
+			}
+
+			x[i] = float64(f)
+		}
+
+		val, err := fn(x)
+		if err != nil || len(duals) == 0 {
+			return Float(val), err
+		}
+
+		result := Dual{Val: val, Grad: make([]float64, len(args[duals[0]].(Dual).Grad))}
+		for _, i := range duals {
+			var partial float64
+			if i < len(partials) && partials[i] != nil {
+				partial, err = partials[i](x)
+			} else {
+				partial, err = centralDiff(fn, x, i)
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			result.Grad = chain(result, 1, args[i].(Dual), partial)
+		}
+
+		return result, nil
+	})
+}
+
+// centralDiff approximates the partial derivative of fn by its i-th argument at x.
+func centralDiff(fn Function, x []float64, i int) (float64, error) {
+	// the step balancing rounding and truncation errors, about the cube root of the epsilon
+	h := 6e-6 * math.Max(1, math.Abs(x[i]))
+	args := append([]float64(nil), x...)
+
+	args[i] = x[i] + h
+	up, err := fn(args)
+	if err != nil {
+		return 0, err
+	}
+
+	args[i] = x[i] - h
+	down, err := fn(args)
+	if err != nil {
+		return 0, err
+	}
+
+	return (up - down) / ((x[i] + h) - (x[i] - h)), nil
+}
+
+// dualModeFuncs replace the value functions of the same name in dual mode.
+var dualModeFuncs = map[string]LazyValueFunction{
+	"abs": eagerValue(func(args []Value) (Value, error) {
+		if len(args) == 1 {
+			if d, ok := args[0].(Dual); ok {
+				sign := 0.0
+				if d.Val != 0 {
+					sign = math.Copysign(1, d.Val)
+				}
+
+				return Dual{math.Abs(d.Val), scaleGrad(d.Grad, sign)}, nil
+			}
+		}
+
+		return abs(args)
+	}),
+	// rounding is constant where it is differentiable
+	"round": eagerValue(func(args []Value) (Value, error) {
+		if len(args) > 0 {
+			if d, ok := args[0].(Dual); ok {
+				args = append([]Value{Float(d.Val)}, args[1:]...)
+			}
+		}
+
+		return builtinRound(args)
+	}),
+}
+
+// dualContext evaluates the variables to differentiate by as duals,
+// and functions of numbers on duals.
+type dualContext struct {
+	parent EvalContext
+	vars   map[string]Dual
+}
+
+func (d *dualContext) LookupVar(name string) (float64, bool) {
+	return d.parent.LookupVar(name)
+}
+
+func (d *dualContext) LookupValue(name string) (Value, bool) {
+	if val, found := d.vars[name]; found {
+		return val, true
+	}
+
+	return lookupValue(d.parent, name)
+}
+
+func (d *dualContext) LookupFunc(name string) (Function, bool) {
+	return d.parent.LookupFunc(name)
+}
+
+func (d *dualContext) LookupLazyFunc(name string) (LazyFunction, bool) {
+	if parent, ok := d.parent.(LazyContext); ok {
+		return parent.LookupLazyFunc(name)
+	}
+
+	return nil, false
+}
+
+// LookupValueFunc keeps the precedence of value functions over lazy ones, and
+// of lazy functions over functions of numbers, which are made to take duals.
+func (d *dualContext) LookupValueFunc(name string) (LazyValueFunction, bool) {
+	if parent, ok := d.parent.(ValueContext); ok {
+		if fn, found := parent.LookupValueFunc(name); found {
+			if dualFn, found := dualModeFuncs[name]; found {
+				return dualFn, true
+			}
+
+			return fn, true
+		}
+	}
+
+	if _, found := d.LookupLazyFunc(name); found {
+		return nil, false
+	}
+
+	if fn, found := d.parent.LookupFunc(name); found {
+		return dualFunc(name, fn, derivatives[name]), true
+	}
+
+	return nil, false
+}
+
+func (d *dualContext) LookupArity(name string) (Arity, bool) {
+	return lookupArity(d.parent, name)
+}
+
+func (d *dualContext) withParent(parent EvalContext) EvalContext {
+	return &dualContext{parent: parent, vars: d.vars}
+}
+
+// LookupNamespace returns the namespace evaluated on duals as well,
+// with the variables to differentiate by that are in it.
+func (d *dualContext) LookupNamespace(name string) (EvalContext, bool) {
+	parent, ok := d.parent.(NamespaceContext)
+	if !ok {
+		return nil, false
+	}
+
+	ns, found := parent.LookupNamespace(name)
+	if !found {
+		return nil, false
+	}
+
+	vars := map[string]Dual{}
+	for qualified, val := range d.vars {
+		if local := strings.TrimPrefix(qualified, name+"."); local != qualified {
+			vars[local] = val
+		}
+	}
+
+	return &dualContext{parent: ns, vars: vars}, true
+}
+
+// EvalGrad evaluates expr together with its gradient, the partial derivatives
+// with respect to the variables wrt, by forward-mode automatic differentiation:
+//
+//	ctx.SetVar("x", 2)
+//	ctx.SetVar("y", 3)
+//	EvalGrad(Parse("x^2 * y + sin(x)"), ctx, []string{"x", "y"}) // 12.909..., [11.583..., 4]
+//
+// Operators and user-defined functions are differentiated exactly. Functions
+// of numbers use the derivatives given with RegisterDerivative, sin and cos
+// have theirs, and are differentiated with central finite differences otherwise.
+// Comparisons are taken as constant, so expressions such as x > 0 ? x : -x
+// have the derivative of the branch taken.
+func EvalGrad(expr Expression, ctx EvalContext, wrt []string) (float64, []float64, error) {
+	vars := make(map[string]Dual, len(wrt))
+	for i, name := range wrt {
+		val, found := lookupValue(ctx, name)
+		if !found {
+			return 0, nil, fmt.Errorf("variable not specified: %s", name)
+		}
+
+		f, ok := realValue(val)
+		if !ok {
+			return 0, nil, fmt.Errorf("%s used as a number: %s", val.Kind(), name)
+		}
+
+		d := Dual{Val: float64(f), Grad: make([]float64, len(wrt))}
+		d.Grad[i] = 1
+		vars[name] = d
+	}
+
+	val, err := expr.EvalValue(&dualContext{parent: ctx, vars: vars})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	switch val := val.(type) {
+	case Dual:
+		return val.Val, val.Grad, nil
+	case Float:
+		return float64(val), make([]float64, len(wrt)), nil
+	default:
+		return 0, nil, fmt.Errorf("%s used as a number: %s", val.Kind(), val)
+	}
+}
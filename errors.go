@@ -0,0 +1,103 @@
+package calculon
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xjem/calculon/internal/lexer"
+)
+
+type (
+	// Position is a location in the parsed input.
+	Position = lexer.Position
+	// Span is a half-open range [Start, End) of the parsed input.
+	Span = lexer.Span
+	// Token is a lexical token of the parsed input.
+	Token = lexer.Token
+	// TokenKind is the kind of Token.
+	TokenKind = lexer.Kind
+)
+
+// ParseError describes a syntax error and where it occurred in the input.
+type ParseError struct {
+	Span     Span
+	Msg      string
+	Expected []TokenKind // token kinds that would have been accepted, if known
+	Found    Token       // offending token
+
+	input string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Span.Start.Line, e.Span.Start.Column, e.Msg)
+}
+
+// Snippet renders the input line containing the error with a caret under the offending part:
+//
+//	sin(x))
+//	      ^
+func (e *ParseError) Snippet() string {
+	start := e.Span.Start.Offset
+	if start > len(e.input) {
+		start = len(e.input)
+	}
+
+	lineStart := strings.LastIndexByte(e.input[:start], '\n') + 1
+	lineEnd := strings.IndexByte(e.input[start:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(e.input)
+	} else {
+		lineEnd += start
+	}
+
+	end := e.Span.End.Offset
+	if end > lineEnd {
+		end = lineEnd
+	}
+
+	width := utf8.RuneCountInString(e.input[start:end])
+	if width == 0 {
+		width = 1
+	}
+
+	var caret strings.Builder
+	for _, r := range e.input[lineStart:start] {
+		if r == '\t' {
+			caret.WriteRune('\t')
+		} else {
+			caret.WriteRune(' ')
+		}
+	}
+	caret.WriteString(strings.Repeat("^", width))
+
+	return e.input[lineStart:lineEnd] + "\n" + caret.String()
+}
+
+// StackOverflowError is returned when calls of user functions nest deeper
+// than MaxCallDepth, usually because a recursion never ends.
+type StackOverflowError struct {
+	Func  string // function whose call went over the limit
+	Depth int    // the limit
+}
+
+func (e *StackOverflowError) Error() string {
+	return fmt.Sprintf("stack overflow: %s: call depth exceeds %d", e.Func, e.Depth)
+}
+
+func describe(tok Token) string {
+	switch tok.Kind {
+	case lexer.EOF:
+		return "end of input"
+	case lexer.Ident:
+		return "identifier " + tok.Value
+	case lexer.Number, lexer.Imaginary:
+		return "number " + tok.Value
+	case lexer.Unexpected:
+		return "character " + tok.Value
+	case lexer.Operator:
+		return "'" + tok.Value + "'"
+	default:
+		return "'" + tok.Kind.String() + "'"
+	}
+}
@@ -1,6 +1,11 @@
 package calculon
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -16,6 +21,27 @@ func TestEval(t *testing.T) {
 		{"2*(3-4)+2/4", -1.5},
 		{"2 * (3 + 4) / 5 - 512 * (-9 + 10) * 332 - 55 / 2", -170008.7},
 		{"4^3^2", 262144},
+		{"1 < 2", 1},
+		{"2 <= 1", 0},
+		{"3 == 1 + 2", 1},
+		{"3 != 3", 0},
+		{"2 > 1 && 1 >= 1", 1},
+		{"0 || 5", 1},
+		{"0 || 0", 0},
+		{"!0 + !7", 1},
+		{"1 xor 1", 0},
+		{"1 xor 0", 1},
+		{"0 && 1/0", 0},
+		{"1 || 1/0", 1},
+		{"5!", 120},
+		{"0!", 1},
+		{"3!!", 720},
+		{"2^3!", 64},
+		{"-3!", -6},
+		{"1.5!", math.Gamma(2.5)},
+		{"200 * 15%", 30},
+		{"50%%", 0.005},
+		{"7 % 4", 3},
 	}
 
 	for _, test := range tests {
@@ -28,3 +54,1279 @@ func TestEval(t *testing.T) {
 		assert.Equal(t, test.expected, result)
 	}
 }
+
+func TestEvalNamespaces(t *testing.T) {
+	phys := NewContext()
+	phys.SetVar("c", 299792458)
+
+	units := NewContext()
+	units.SetVar("km", 1000)
+	phys.SetNamespace("units", units)
+
+	stats := NewContext()
+	stats.SetFunc("mean", func(args []float64) (float64, error) {
+		sum := 0.0
+		for _, arg := range args {
+			sum += arg
+		}
+
+		return sum / float64(len(args)), nil
+	})
+
+	ctx := MathContext()
+	ctx.SetNamespace("phys", phys)
+	ctx.SetNamespace("stats", stats)
+	ctx.SetVar("x2", 4)
+	ctx.SetVar("Δt", 0.5)
+	ctx.SetVar("legacy.name", 7)
+
+	tests := []struct {
+		input    string
+		expected float64
+		err      string
+	}{
+		{input: "phys.c / phys.units.km", expected: 299792.458},
+		{input: "stats.mean(x2, Δt, 1.5)", expected: 2},
+		{input: "legacy.name", expected: 7},
+		{input: "phys.sin(0)", err: "function not specified: phys.sin"},
+		{input: "phys.h", err: "variable not specified: phys.h"},
+	}
+
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		assert.NoError(t, err)
+
+		result, err := expr.Eval(ctx)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.input)
+			continue
+		}
+
+		assert.NoError(t, err, test.input)
+		assert.Equal(t, test.expected, result, test.input)
+	}
+
+	// functions and variables of namespaces are differentiated as well
+	phys.SetFunc("sq", func(args []float64) (float64, error) { return args[0] * args[0], nil })
+	expr, err := Parse("phys.sq(x2) + phys.units.km * x2")
+	assert.NoError(t, err)
+	value, grad, err := EvalGrad(expr, ctx, []string{"x2", "phys.units.km"})
+	assert.NoError(t, err)
+	assert.Equal(t, 4016.0, value)
+	assert.InDeltaSlice(t, []float64{1008, 4}, grad, 1e-6)
+}
+
+func TestEvalLazy(t *testing.T) {
+	ctx := MathContext()
+	ctx.SetVar("x", 0)
+
+	calls := 0
+	ctx.SetFunc("count", func(args []float64) (float64, error) {
+		calls++
+		return 1, nil
+	})
+
+	tests := []struct {
+		input    string
+		expected float64
+		calls    int
+	}{
+		{input: "x != 0 ? 1/x : 0", expected: 0},
+		{input: "x == 0 ? count() : count()", expected: 1, calls: 1},
+		{input: "if(x != 0, 1/x, 0)", expected: 0},
+		{input: "if(1, count(), count() + count())", expected: 1, calls: 1},
+		{input: "coalesce(1/x, y, 0/0 * 0, 5)", expected: 5},
+		{input: "and(x, count())", expected: 0},
+		{input: "or(count(), 1/x)", expected: 1, calls: 1},
+	}
+
+	for _, test := range tests {
+		calls = 0
+
+		expr, err := Parse(test.input)
+		assert.NoError(t, err)
+
+		result, err := expr.Eval(ctx)
+		assert.NoError(t, err, test.input)
+		assert.Equal(t, test.expected, result, test.input)
+		assert.Equal(t, test.calls, calls, test.input)
+	}
+}
+
+func TestEvalFactorialErrors(t *testing.T) {
+	expr, err := Parse("(-2)!")
+	assert.NoError(t, err)
+
+	_, err = expr.Eval(EmptyContext{})
+	assert.EqualError(t, err, "factorial of negative integer: -2")
+}
+
+func TestRunProgram(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+		err      string
+	}{
+		{input: "x = 3; y = x * 2; f(a) = a^2 + y; f(y)", expected: 42},
+		{input: "x = 3\nx = x + 1\nx", expected: 4},
+		{input: "f(a, b) = a - b\ng(a) = f(a, 1) * 2\ng(5)", expected: 8},
+		{input: "y = 1; f(x) = x + y; y = 10; f(1)", expected: 11},
+		{input: "x = 5; f(x) = x; f(1) + x", expected: 6},
+		{input: "x = 7", expected: 7},
+		{input: "", expected: 0},
+		{input: "f(a) = a; f(1, 2)", err: "f(a): bad params count (want 1, got 2)"},
+		{input: "x = 1; y", err: "variable not specified: y"},
+	}
+
+	for _, test := range tests {
+		prog, err := ParseProgram(test.input)
+		if !assert.NoError(t, err, test.input) {
+			continue
+		}
+
+		result, err := prog.Run(NewScope(MathContext()))
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.input)
+			continue
+		}
+
+		assert.NoError(t, err, test.input)
+		assert.Equal(t, test.expected, result, test.input)
+	}
+}
+
+func TestRunProgramContext(t *testing.T) {
+	ctx := MathContext()
+
+	prog, err := ParseProgram("r = 2; area(r) = Pi * r^2")
+	assert.NoError(t, err)
+
+	scope := NewScope(ctx)
+	_, err = prog.Run(scope)
+	assert.NoError(t, err)
+
+	// definitions stay in the scope
+	_, found := ctx.LookupVar("r")
+	assert.False(t, found)
+
+	expr, err := Parse("area(r)")
+	assert.NoError(t, err)
+
+	result, err := expr.Eval(scope)
+	assert.NoError(t, err)
+	assert.Equal(t, 4*math.Pi, result)
+
+	_, err = Assignment{Name: "x", Value: Number{Value: 1}}.Eval(EmptyContext{})
+	assert.EqualError(t, err, "cannot assign x: context is read-only")
+}
+
+func TestEvalLambda(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+		err      string
+	}{
+		{input: "sq = x -> x^2; sq(3)", expected: 9},
+		{input: "mul = (a, b) -> a * b; mul(2, 3)", expected: 6},
+		{input: "apply(x -> x + 1, 2)", expected: 3},
+		{input: "apply((a, b) -> a - b, 5, 2)", expected: 3},
+		{input: "apply(cos, 0)", expected: 1},
+		{input: "sum(i -> i^2, 1, 4)", expected: 30},
+		{input: "sum(i -> i, 0.5, 3.5)", expected: 6},
+		{input: "sum(i -> i, 3, 1)", expected: 0},
+		{input: "sum(i -> 1, 1e16, 1e16 + 4)", expected: 5},
+		{input: "twice(f, x) = f(f(x)); twice(x -> 2 * x, 3)", expected: 12},
+		{input: "twice(f, x) = f(f(x)); inc(x) = x + 1; twice(inc, 3)", expected: 5},
+		{input: "k = 10; add(x) = apply(y -> x + y + k, 1); add(2)", expected: 13},
+		{input: "c = cos; c(0)", expected: 1},
+		{input: "one = () -> 1; one()", expected: 1},
+		{input: "f = x -> x; f(1, 2)", err: "lambda(x): bad params count (want 1, got 2)"},
+		{input: "(x -> x) + 1", err: "function used as a number: x -> x"},
+		{input: "apply(2, 3)", err: "apply(): not a function: 2"},
+		{input: "sum(i -> i, 1, 1e308 * 10)", err: "sum(): bounds must be finite: 1, +Inf"},
+		{input: "sum(i -> i, 1, 1e300)", err: "sum(): too many terms: 1e+300"},
+	}
+
+	for _, test := range tests {
+		prog, err := ParseProgram(test.input)
+		if !assert.NoError(t, err, test.input) {
+			continue
+		}
+
+		result, err := prog.Run(NewScope(MathContext()))
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.input)
+			continue
+		}
+
+		assert.NoError(t, err, test.input)
+		assert.Equal(t, test.expected, result, test.input)
+	}
+}
+
+func TestEvalRecursion(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{input: "fact(n) = if(n <= 1, 1, n * fact(n - 1)); fact(10)", expected: 3628800},
+		{input: "fib(n) = n < 2 ? n : fib(n - 1) + fib(n - 2); fib(15)", expected: 610},
+		{input: "f(x) = x * 2; f(f(f(2)))", expected: 16},
+		{input: "f(x) = x + 1; g(x) = f(x) * f(x + 1); g(f(1))", expected: 12},
+		{input: "even(n) = n == 0 ? 1 : odd(n - 1); odd(n) = n == 0 ? 0 : even(n - 1); even(10)", expected: 1},
+		// the caller's x is still 1 after f returns
+		{input: "f(x) = x == 0 ? 0 : f(x - 1) + x; x = 1; f(3) + x", expected: 7},
+		{input: "sumTo(n) = sum(i -> i, 1, n); fact(n) = n <= 1 ? 1 : n * fact(n - 1); fact(sumTo(3))", expected: 720},
+	}
+
+	for _, test := range tests {
+		prog, err := ParseProgram(test.input)
+		if !assert.NoError(t, err, test.input) {
+			continue
+		}
+
+		result, err := prog.Run(NewScope(MathContext()))
+		assert.NoError(t, err, test.input)
+		assert.Equal(t, test.expected, result, test.input)
+	}
+}
+
+func TestEvalStackOverflow(t *testing.T) {
+	prog, err := ParseProgram("f(n) = f(n + 1) + 1; f(0)")
+	assert.NoError(t, err)
+
+	_, err = prog.Run(NewScope(MathContext()))
+
+	var overflow *StackOverflowError
+	if assert.True(t, errors.As(err, &overflow), "expected *StackOverflowError, got %v", err) {
+		assert.Equal(t, "f(n)", overflow.Func)
+		assert.Equal(t, MaxCallDepth, overflow.Depth)
+	}
+
+	prog, err = ParseProgram("depth(n) = n == 0 ? 0 : 1 + depth(n - 1)")
+	assert.NoError(t, err)
+
+	ctx := MathContext()
+	ctx.SetMaxCallDepth(20)
+	scope := NewScope(ctx)
+	_, err = prog.Run(scope)
+	assert.NoError(t, err)
+
+	result, err := FunctionCall{Name: "depth", Args: []Expression{Number{Value: 19}}}.Eval(scope)
+	assert.NoError(t, err)
+	assert.Equal(t, 19.0, result)
+
+	_, err = FunctionCall{Name: "depth", Args: []Expression{Number{Value: 20}}}.Eval(scope)
+	assert.EqualError(t, err, "stack overflow: depth(n): call depth exceeds 20")
+
+	// the limit of a scope overrides that of its parent
+	scope.SetMaxCallDepth(10)
+
+	result, err = FunctionCall{Name: "depth", Args: []Expression{Number{Value: 9}}}.Eval(scope)
+	assert.NoError(t, err)
+	assert.Equal(t, 9.0, result)
+
+	_, err = FunctionCall{Name: "depth", Args: []Expression{Number{Value: 10}}}.Eval(scope)
+	assert.EqualError(t, err, "stack overflow: depth(n): call depth exceeds 10")
+}
+
+func TestEvalConcurrentCalls(t *testing.T) {
+	prog, err := ParseProgram("f(x) = x == 0 ? 0 : f(x - 1) + x")
+	assert.NoError(t, err)
+
+	scope := NewScope(MathContext())
+	_, err = prog.Run(scope)
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	results := make([]float64, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = FunctionCall{Name: "f", Args: []Expression{Number{Value: float64(i * 10)}}}.Eval(scope)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		n := float64(i * 10)
+		assert.Equal(t, n*(n+1)/2, result)
+	}
+}
+
+func TestEvalLet(t *testing.T) {
+	ctx := MathContext()
+	ctx.SetVar("dx", 3)
+	ctx.SetVar("dy", 4)
+	ctx.SetVar("d", -1)
+	ctx.SetFunc("sqrt", func(args []float64) (float64, error) {
+		return math.Sqrt(args[0]), nil
+	})
+
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{input: "let d = sqrt(dx^2 + dy^2), k = 2 in k*d/(1+d)", expected: 10.0 / 6},
+		{input: "k*d/(1+d) where d = sqrt(dx^2 + dy^2), k = 2", expected: 10.0 / 6},
+		{input: "let d = 1, d = d + 1 in d", expected: 2},
+		{input: "(let d = 5 in d) + d", expected: 4},
+		{input: "let sq(x) = x^2 in sq(dx) + sq(dy)", expected: 25},
+		{input: "apply(f, 2) where f = x -> x * dx", expected: 6},
+		{input: "let dx = 1 in let dy = dx + 1 in dx + dy", expected: 3},
+	}
+
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		if !assert.NoError(t, err, test.input) {
+			continue
+		}
+
+		result, err := expr.Eval(ctx)
+		assert.NoError(t, err, test.input)
+		assert.Equal(t, test.expected, result, test.input)
+	}
+
+	// bindings never leak into the context
+	val, _ := ctx.LookupVar("d")
+	assert.Equal(t, -1.0, val)
+	_, found := ctx.LookupVar("k")
+	assert.False(t, found)
+	_, found = ctx.LookupLazyFunc("sq")
+	assert.False(t, found)
+}
+
+func TestEvalVectors(t *testing.T) {
+	ctx := MathContext()
+	ctx.SetValue("v", Vector{1, 2, 3})
+	ctx.SetValue("w", Vector{3, 4})
+	ctx.SetVar("x", 2)
+
+	tests := []struct {
+		input    string
+		expected Value
+		err      string
+	}{
+		{input: "[1, 2] * 2", expected: Vector{2, 4}},
+		{input: "2 - v", expected: Vector{1, 0, -1}},
+		{input: "v + v", expected: Vector{2, 4, 6}},
+		{input: "-v^2", expected: Vector{-1, -4, -9}},
+		{input: "v > 1", expected: Vector{0, 1, 1}},
+		{input: "[x, x + 1]!", expected: Vector{2, 6}},
+		{input: "v[0] + v[2]", expected: Float(4)},
+		{input: "len(v)", expected: Float(3)},
+		{input: "len([])", expected: Float(0)},
+		{input: "mean(v)", expected: Float(2)},
+		{input: "mean(v, 6)", expected: Float(3)},
+		{input: "sum(v)", expected: Float(6)},
+		{input: "dot(v, v)", expected: Float(14)},
+		{input: "norm(w)", expected: Float(5)},
+		{input: "x > 1 ? v : w", expected: Vector{1, 2, 3}},
+		{input: "let u = v * x in u[2]", expected: Float(6)},
+		{input: "v + w", err: "vector lengths differ: 3 and 2"},
+		{input: "v[3]", err: "vector index out of range: 3 with length 3"},
+		{input: "v[0.5]", err: "vector index is not an integer: 0.5"},
+		{input: "x[0]", err: "cannot index x: not a vector"},
+		{input: "[v]", err: "vector element is not a number: v"},
+		{input: "sin(v)", err: "vector used as a number: [1, 2, 3]"},
+		{input: "v ? 1 : 0", err: "vector used as a condition: [1, 2, 3]"},
+		{input: "dot(v, w)", err: "dot(): vector lengths differ: 3 and 2"},
+		{input: "norm(x)", err: "norm(): not a vector: x"},
+	}
+
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		if !assert.NoError(t, err, test.input) {
+			continue
+		}
+
+		result, err := expr.EvalValue(ctx)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.input)
+			continue
+		}
+
+		assert.NoError(t, err, test.input)
+		assert.Equal(t, test.expected, result, test.input)
+	}
+
+	_, err := Variable{Name: "v"}.Eval(ctx)
+	assert.EqualError(t, err, "vector used as a number: [1, 2, 3]")
+
+	ctx.SetVar("v", 1)
+	val, err := Variable{Name: "v"}.EvalValue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, Float(1), val)
+}
+
+func TestRunProgramVectors(t *testing.T) {
+	prog, err := ParseProgram("samples = [2, 4, 4, 4, 5, 5, 7, 9]\nm = mean(samples)\nsd(v) = sqrt(mean((v - mean(v))^2))\n[m, sd(samples)]")
+	assert.NoError(t, err)
+
+	scope := NewScope(MathContext())
+	scope.SetFunc("sqrt", func(args []float64) (float64, error) { return math.Sqrt(args[0]), nil })
+
+	result, err := prog.RunValue(scope)
+	assert.NoError(t, err)
+	assert.Equal(t, Vector{5, 2}, result)
+	assert.Equal(t, "[5, 2]", result.String())
+
+	_, err = prog.Run(scope)
+	assert.EqualError(t, err, "vector used as a number: [5, 2]")
+}
+
+// define runs a program defining names in ctx.
+func define(t *testing.T, ctx MutableContext, program string) {
+	prog, err := ParseProgram(program)
+	if assert.NoError(t, err, program) {
+		_, err = prog.Run(ctx)
+		assert.NoError(t, err, program)
+	}
+}
+
+func TestEvalComplex(t *testing.T) {
+	ctx := MathContext()
+	ctx.SetValue("z", Complex(3+4i))
+	ctx.SetVar("x", 2)
+
+	tests := []struct {
+		input    string
+		expected Value
+		err      string
+	}{
+		{input: "1 + 2i", expected: Complex(1 + 2i)},
+		{input: "2i * 3i", expected: Complex(-6)},
+		{input: "z / (1 - 2i)", expected: Complex(-1 + 2i)},
+		{input: "x - z", expected: Complex(-1 - 4i)},
+		{input: "-z", expected: Complex(-3 - 4i)},
+		{input: "z == 3 + 4i", expected: Float(1)},
+		{input: "z != z", expected: Float(0)},
+		{input: "re(z) + im(z)", expected: Float(7)},
+		{input: "abs(z)", expected: Float(5)},
+		{input: "arg(2i)", expected: Float(math.Pi / 2)},
+		{input: "conj(z)", expected: Complex(3 - 4i)},
+		{input: "csqrt(-4)", expected: Complex(2i)},
+		{input: "cexp(0)", expected: Complex(1)},
+		{input: "abs(-2)", expected: Float(2)},
+		{input: "2i * 2i < 0", expected: Float(1)},
+		{input: "(1i * 1i + 4)!", expected: Float(6)},
+		{input: "[1i * 1i, 2]", expected: Vector{-1, 2}},
+		{input: "z ? 1 : 0", err: "complex used as a condition: 3+4i"},
+		{input: "z < 1", err: "operator < is not defined for complex and number"},
+		{input: "sin(z)", err: "complex used as a number: 3+4i"},
+		{input: "z / 0", err: "divide by zero"},
+		{input: "re([1])", err: "re(): not a number: [1]"},
+		{input: "im(1, 2)", err: "im() requires 1 arg"},
+	}
+
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		if !assert.NoError(t, err, test.input) {
+			continue
+		}
+
+		result, err := expr.EvalValue(ctx)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.input)
+			continue
+		}
+
+		assert.NoError(t, err, test.input)
+		assert.Equal(t, test.expected, result, test.input)
+	}
+
+	_, err := Imaginary{Value: 2}.Eval(ctx)
+	assert.EqualError(t, err, "complex used as a number: 2i")
+
+	for c, str := range map[Complex]string{1 + 2i: "1+2i", 1 - 2i: "1-2i", -2i: "-2i", 2.5: "2.5", 0: "0"} {
+		assert.Equal(t, str, c.String())
+	}
+}
+
+func TestEvalComplexMode(t *testing.T) {
+	scope := NewScope(MathContext())
+	scope.SetVar("x", -4)
+	define(t, scope, "c(w) = sin(w) + w / 2")
+
+	tests := []struct {
+		input    string
+		expected complex128
+		err      string
+	}{
+		{input: "sqrt(-1)", expected: 1i},
+		{input: "sqrt(x)", expected: 2i},
+		{input: "x^0.5", expected: complex(0, 2)},
+		{input: "(-8)^(1/3)", expected: complex(1, math.Sqrt(3))},
+		{input: "exp(1i * Pi) + 1", expected: 0},
+		{input: "log(-1)", expected: complex(0, math.Pi)},
+		{input: "sin(1i)", expected: complex(0, math.Sinh(1))},
+		{input: "let f(z) = z * conj(z) in f(3 + 4i)", expected: 25},
+		{input: "c(2i)", expected: complex(0, math.Sinh(2)+1)},
+		{input: "mean(1i, 1, [2, 3])", expected: complex(1.5, 0.25)},
+		{input: "c(sqrt(x))", expected: complex(0, math.Sinh(2)+1)},
+		{input: "x < 0 ? 1 : 2", expected: 1},
+		{input: "[1, 2][1]", expected: 2},
+		{input: "y", err: "variable not specified: y"},
+		{input: "[1, 2]", err: "vector used as a complex number: [1, 2]"},
+	}
+
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		if !assert.NoError(t, err, test.input) {
+			continue
+		}
+
+		result, err := EvalComplex(expr, scope)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.input)
+			continue
+		}
+
+		if assert.NoError(t, err, test.input) {
+			assert.InDelta(t, real(test.expected), real(result), 1e-12, test.input)
+			assert.InDelta(t, imag(test.expected), imag(result), 1e-12, test.input)
+		}
+	}
+
+	// real mode is untouched
+	expr, err := Parse("x^0.5")
+	assert.NoError(t, err)
+	result, err := expr.Eval(scope)
+	assert.NoError(t, err)
+	assert.True(t, math.IsNaN(result))
+
+	// programs define names in a scope over the mode
+	prog, err := ParseProgram("r = 2\nf(w) = w * r\nf(x) + r*i where i = 1i")
+	assert.NoError(t, err)
+	val, err := prog.RunValue(NewScope(ComplexMode(scope)))
+	assert.NoError(t, err)
+	assert.Equal(t, "-8+2i", val.String())
+}
+
+func TestEvalRational(t *testing.T) {
+	scope := NewScope(MathContext())
+	scope.SetVar("x", 0.1)
+	define(t, scope, "h(w) = w / 3")
+
+	tests := []struct {
+		input    string
+		expected string
+		err      string
+	}{
+		{input: "2*(3-4)+2/4", expected: "-3/2"},
+		{input: "h(1) + h(x)", expected: "11/30"},
+		{input: "mean(1/3, 1/2, x)", expected: "14/45"},
+		{input: "0.1 + 0.2", expected: "3/10"},
+		{input: "0.1 + 0.2 == 0.3", expected: "1"},
+		{input: "x * 3", expected: "3/10"},
+		{input: "1/3 < 0.3333333333333333", expected: "0"},
+		{input: "(2/3)^-3", expected: "27/8"},
+		{input: "7.5 % 2", expected: "3/2"},
+		{input: "-7.5 % 2", expected: "-3/2"},
+		{input: "0x10 / 1_000", expected: "2/125"},
+		{input: "1e-3 * 25%", expected: "1/4000"},
+		{input: "abs(-1/3)", expected: "1/3"},
+		{input: "0 && 1/0 || !(1/2)", expected: "0"},
+		{input: "1/2 xor 0", expected: "1"},
+		{input: "let f(n) = n <= 1 ? 1 : n * f(n - 1) in f(25) / f(23)", expected: "600"},
+		{input: "3!", expected: "6"},
+		{input: "0! + 25! / 24!", expected: "26"},
+		{input: "len([1]) / 3", expected: "1/3"},
+		{input: "(-2)!", err: "factorial of negative integer: -2"},
+		{input: "sin(1) * 1e20", err: "inexact result: 84147098480789650000"},
+		{input: "cos(1e-9)", err: "inexact result: 1"},
+		{input: "sin(Pi/2)", err: "inexact result: 1"},
+		{input: "sum(i -> 1/i, 1, 4)", err: "inexact result: 2.083333333333333"},
+		{input: "sin(1/2)", err: "inexact result: 0.479425538604203"},
+		{input: "4^0.5 + 1/2", err: "inexact result: 2.5"},
+		{input: "1/0", err: "divide by zero"},
+		{input: "[1/2]", err: "vector used as a rational number: [0.5]"},
+	}
+
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		if !assert.NoError(t, err, test.input) {
+			continue
+		}
+
+		result, err := EvalRational(expr, scope)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.input)
+			continue
+		}
+
+		if assert.NoError(t, err, test.input) {
+			assert.Equal(t, test.expected, result.RatString(), test.input)
+		}
+	}
+
+	expr, err := Parse("(1/3 + 1/6) * 2^0.5")
+	assert.NoError(t, err)
+	val, err := expr.EvalValue(RationalMode(scope))
+	assert.NoError(t, err)
+	assert.Equal(t, Float(math.Sqrt2/2), val)
+}
+
+func TestEvalBigFloat(t *testing.T) {
+	scope := NewScope(MathContext())
+	scope.SetVar("x", 0.1)
+	define(t, scope, "s(w) = sqrt(w)")
+
+	tests := []struct {
+		input    string
+		expected string // to 50 significant digits
+		err      string
+	}{
+		{input: "Pi", expected: "3.1415926535897932384626433832795028841971693993751"},
+		{input: "E", expected: "2.7182818284590452353602874713526624977572470937"},
+		{input: "sin(1)", expected: "0.84147098480789650665250232163029899962256306079837"},
+		{input: "cos(100)", expected: "0.86231887228768393410193851395084253551008400853551"},
+		{input: "exp(-2.5)", expected: "0.082084998623898795169528674467159807837804121015437"},
+		{input: "log(2)", expected: "0.69314718055994530941723212145817656807550013436026"},
+		{input: "log(1e100)", expected: "230.25850929940456840179914546843642076011014886288"},
+		{input: "sqrt(2)", expected: "1.4142135623730950488016887242096980785696718753769"},
+		{input: "2^0.5", expected: "1.4142135623730950488016887242096980785696718753769"},
+		{input: "2^100", expected: "1267650600228229401496703205376"},
+		{input: "x * 3 == 0.3", expected: "1"},
+		{input: "1e-30 / 4", expected: "2.5e-31"},
+		{input: "sum([1, 2]) / 3", expected: "1"},
+		{input: "let f(n) = n <= 1 ? 1 : n * f(n - 1) in f(30)", expected: "265252859812191058636308480000000"},
+		{input: "s(2)", expected: "1.4142135623730950488016887242096980785696718753769"},
+		{input: "sqrt(-1)", err: "result is not a number"},
+		{input: "1/0", err: "divide by zero"},
+		{input: "[Pi]", err: "vector used as a number: [3.141592653589793]"},
+	}
+
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		if !assert.NoError(t, err, test.input) {
+			continue
+		}
+
+		result, err := EvalBigFloat(expr, scope, 256)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.input)
+			continue
+		}
+
+		if assert.NoError(t, err, test.input) {
+			assert.Equal(t, test.expected, result.Text('g', 50), test.input)
+			assert.Equal(t, uint(256), result.Prec(), test.input)
+		}
+	}
+
+	expr, err := Parse("1/3")
+	assert.NoError(t, err)
+	val, err := expr.EvalValue(PrecisionMode(scope, 100))
+	assert.NoError(t, err)
+	assert.Equal(t, "0.333333333333333333333333333333", val.String())
+	assert.Equal(t, "0.333333333333333333333333333333", fmt.Sprint(val))
+}
+
+func TestEvalDecimal(t *testing.T) {
+	scope := NewScope(MathContext())
+	scope.SetVar("price", 19.99)
+	define(t, scope, "g(w) = w + 1 / 3 * 3")
+
+	tests := []struct {
+		input    string
+		scale    int
+		rounding Rounding
+		expected string
+		err      string
+	}{
+		{input: "0.1 * 3", scale: 2, expected: "0.30"},
+		{input: "price * 3 * 1.0725", scale: 2, rounding: RoundHalfEven, expected: "64.32"},
+		{input: "price * 3 * 1.0725", scale: 2, rounding: RoundTruncate, expected: "64.31"},
+		{input: "0.125 + 0", scale: 2, rounding: RoundHalfEven, expected: "0.12"},
+		{input: "0.125 + 0", scale: 2, rounding: RoundHalfUp, expected: "0.13"},
+		{input: "-0.125 + 0", scale: 2, rounding: RoundHalfUp, expected: "-0.13"},
+		{input: "-2/3", scale: 2, rounding: RoundTruncate, expected: "-0.66"},
+		{input: "1/3 * 3", scale: 4, expected: "0.9999"},
+		{input: "g(1)", scale: 4, expected: "1.9999"},
+		{input: "mean(0.1, 0.2, price)", scale: 2, expected: "6.76"},
+		{input: "1 / 8", scale: 0, expected: "0"},
+		{input: "round(2.345, 2)", scale: 4, rounding: RoundHalfEven, expected: "2.3400"},
+		{input: "round(2.345, 2)", scale: 4, rounding: RoundHalfUp, expected: "2.3500"},
+		{input: "round(2.5)", scale: 2, rounding: RoundHalfEven, expected: "2.00"},
+		{input: "round(0.5, 4)", scale: 2, expected: "0.50"},
+		{input: "1.05^12", scale: 4, expected: "1.7959"},
+		{input: "2^0.5", scale: 6, expected: "1.414214"},
+		{input: "sin(0.5)", scale: 3, expected: "0.479"},
+		{input: "abs(-1.5) + 15%", scale: 2, expected: "1.65"},
+		{input: "0.1 + 0.2 == 0.3", scale: 2, expected: "1.00"},
+		{input: "7 % 0.5", scale: 1, expected: "0.0"},
+		{input: "1 / 0", scale: 2, err: "divide by zero"},
+		{input: "log(-1)", scale: 2, err: "result is not a decimal number: NaN"},
+		{input: "round(1, 0.5)", scale: 2, err: "round(): digits must be an integer from 0 to 1000: 0.50"},
+	}
+
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		if !assert.NoError(t, err, test.input) {
+			continue
+		}
+
+		scope.SetFunc("log", func(args []float64) (float64, error) { return math.Log(args[0]), nil })
+		result, err := EvalDecimal(expr, scope, test.scale, test.rounding)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.input)
+			continue
+		}
+
+		if assert.NoError(t, err, test.input) {
+			assert.Equal(t, test.expected, result.String(), test.input)
+			assert.Equal(t, test.scale, result.Scale(), test.input)
+		}
+	}
+
+	// literals keep their digits, results of operators are rounded
+	for input, expected := range map[string]string{"0.0725": "0.0725", "0.0725 * 1": "0.07", "-0.5": "-0.50"} {
+		expr, err := Parse(input)
+		assert.NoError(t, err)
+		val, err := expr.EvalValue(DecimalMode(scope, 2, RoundHalfEven))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, val.String(), input)
+	}
+
+	assert.Equal(t, "0", Decimal{}.String())
+	assert.Equal(t, "-0.05", NewDecimal(big.NewRat(-1, 20), 2, RoundHalfEven).String())
+
+	for input, expected := range map[string]float64{"round(2.5)": 2, "round(3.5)": 4, "round(2.675, 2)": 2.67, "round(-1.25, 1)": -1.2} {
+		expr, err := Parse(input)
+		assert.NoError(t, err)
+		result, err := expr.Eval(scope)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, result, input)
+	}
+}
+
+func TestEvalInterval(t *testing.T) {
+	scope := NewScope(MathContext())
+	scope.SetValue("r", Interval{9.9, 10.1})
+	scope.SetValue("x", Interval{-2, 3})
+	scope.SetVar("n", 2)
+	define(t, scope, "tenth(w) = w * 0.1")
+
+	tests := []struct {
+		input    string
+		expected Interval
+		err      string
+	}{
+		{input: "1 + 2", expected: Interval{3, 3}},
+		{input: "n * r", expected: Interval{19.8, 20.2}},
+		{input: "0.1", expected: Interval{0.09999999999999999, 0.1}},
+		{input: "0.1 + 0.2", expected: Interval{0.29999999999999993, 0.30000000000000004}},
+		{input: "tenth(1)", expected: Interval{0.09999999999999999, 0.1}},
+		{input: "-x", expected: Interval{-3, 2}},
+		{input: "x - x", expected: Interval{-5, 5}},
+		{input: "x * x", expected: Interval{-6, 9}},
+		{input: "x^2", expected: Interval{0, 9}},
+		{input: "x^3", expected: Interval{-8, 27}},
+		{input: "x^-2", expected: Interval{0.1111111111111111, math.Inf(1)}},
+		{input: "1 / x", expected: Interval{math.Inf(-1), math.Inf(1)}},
+		{input: "1 / (x - 3)", expected: Interval{math.Inf(-1), -0.19999999999999998}},
+		{input: "(x + 3) / (x + 2)", expected: Interval{0.19999999999999998, math.Inf(1)}},
+		{input: "Pi * r^2", expected: Interval{307.9074959783356, 320.4738665926949}},
+		{input: "sin(x)", expected: Interval{-1, 1}},
+		{input: "cos(x)", expected: Interval{-0.9899924966004455, 1}},
+		{input: "exp(x)", expected: Interval{0.13533528323661267, 20.08553692318767}},
+		{input: "log(x)", expected: Interval{math.Inf(-1), 1.0986122886681098}},
+		{input: "sqrt(x)", expected: Interval{0, 1.7320508075688774}},
+		{input: "abs(x)", expected: Interval{0, 3}},
+		{input: "r < 11", expected: Interval{1, 1}},
+		{input: "r > 11", expected: Interval{0, 0}},
+		{input: "r < 10", expected: Interval{0, 1}},
+		{input: "r > 11 ? 1 : 2", expected: Interval{2, 2}},
+		{input: "r < 10 ? 1 : 2", err: "interval used as a condition: [0, 1]"},
+		{input: "1 / (x - x + 5 > 0)", expected: Interval{1, math.Inf(1)}},
+		{input: "(-r)^0.5", err: "interval power of a negative base: [-10.1, -9.9]^[0.5, 0.5]"},
+		{input: "log(x - 3)", err: "log(): not defined for [-5, 0]"},
+		{input: "1 / 0", err: "divide by zero"},
+		{input: "2i", err: "complex used as an interval: 2i"},
+	}
+
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		if !assert.NoError(t, err, test.input) {
+			continue
+		}
+
+		result, err := EvalInterval(expr, scope)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.input)
+			continue
+		}
+
+		if assert.NoError(t, err, test.input) {
+			assert.Equal(t, test.expected, result, test.input)
+		}
+	}
+
+	// bounds hold the exact results
+	for input, exact := range map[string]float64{"sin(0.1)": math.Sin(0.1), "2^0.5": math.Sqrt2, "E": math.E, "Pi": math.Pi} {
+		expr, err := Parse(input)
+		assert.NoError(t, err)
+		result, err := EvalInterval(expr, scope)
+		assert.NoError(t, err)
+		assert.True(t, result.Contains(exact), input)
+		assert.True(t, result.Hi-result.Lo < 1e-15, input)
+	}
+
+	assert.Equal(t, "[-1.5, 2]", Interval{-1.5, 2}.String())
+}
+
+func TestEvalGrad(t *testing.T) {
+	scope := NewScope(MathContext())
+	scope.SetVar("x", 2)
+	scope.SetVar("y", 3)
+	scope.SetFunc("hypot", func(args []float64) (float64, error) { return math.Hypot(args[0], args[1]), nil })
+	define(t, scope, "cube(t) = t^3\ng(t) = sin(t) * t")
+
+	tests := []struct {
+		input string
+		value float64
+		grad  []float64
+		err   string
+	}{
+		{input: "x^2 * y + sin(x)", value: 12 + math.Sin(2), grad: []float64{12 + math.Cos(2), 4}},
+		{input: "x / y", value: 2.0 / 3, grad: []float64{1.0 / 3, -2.0 / 9}},
+		{input: "y^x", value: 9, grad: []float64{9 * math.Log(3), 6}},
+		{input: "cos(x * y)", value: math.Cos(6), grad: []float64{-3 * math.Sin(6), -2 * math.Sin(6)}},
+		{input: "-(x - y) + 50%", value: 1.5, grad: []float64{-1, 1}},
+		{input: "cube(x) + y", value: 11, grad: []float64{12, 1}},
+		{input: "mean(x, y, 4)", value: 3, grad: []float64{1.0 / 3, 1.0 / 3}},
+		{input: "g(x) + g(y)", value: 2*math.Sin(2) + 3*math.Sin(3), grad: []float64{math.Sin(2) + 2*math.Cos(2), math.Sin(3) + 3*math.Cos(3)}},
+		{input: "let a = x * y in a^2", value: 36, grad: []float64{36, 24}},
+		{input: "x > y ? x : y^2", value: 9, grad: []float64{0, 6}},
+		{input: "abs(x - y)", value: 1, grad: []float64{-1, 1}},
+		{input: "round(x * y) + 1", value: 7, grad: []float64{0, 0}},
+		{input: "7", value: 7, grad: []float64{0, 0}},
+		{input: "x!", err: "operator ! is not defined for dual"},
+		{input: "z", err: "variable not specified: z"},
+	}
+
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		if !assert.NoError(t, err, test.input) {
+			continue
+		}
+
+		value, grad, err := EvalGrad(expr, scope, []string{"x", "y"})
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.input)
+			continue
+		}
+
+		if assert.NoError(t, err, test.input) {
+			assert.InDelta(t, test.value, value, 1e-12, test.input)
+			assert.InDeltaSlice(t, test.grad, grad, 1e-12, test.input)
+		}
+	}
+
+	// functions without derivatives are differentiated numerically
+	expr, err := Parse("hypot(x, y)")
+	assert.NoError(t, err)
+	value, grad, err := EvalGrad(expr, scope, []string{"x", "y"})
+	assert.NoError(t, err)
+	assert.InDelta(t, math.Sqrt(13), value, 1e-12)
+	assert.InDeltaSlice(t, []float64{2 / math.Sqrt(13), 3 / math.Sqrt(13)}, grad, 1e-9)
+
+	RegisterDerivative("hypot",
+		func(args []float64) (float64, error) { return args[0] / math.Hypot(args[0], args[1]), nil },
+		func(args []float64) (float64, error) { return args[1] / math.Hypot(args[0], args[1]), nil })
+	defer delete(derivatives, "hypot")
+
+	_, grad, err = EvalGrad(expr, scope, []string{"y"})
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{3 / math.Hypot(2, 3)}, grad)
+
+	_, _, err = EvalGrad(expr, scope, []string{"w"})
+	assert.EqualError(t, err, "variable not specified: w")
+}
+
+func TestDerive(t *testing.T) {
+	tests := []struct {
+		input    string
+		variable string
+		expected string
+		err      string
+	}{
+		{input: "sin(x) * 2", variable: "x", expected: "cos(x) * 2"},
+		{input: "sin(2 * x)", variable: "x", expected: "cos(2 * x) * 2"},
+		{input: "x^3 + 2 * x - 7", variable: "x", expected: "3 * x^2 + 2"},
+		{input: "x^-2", variable: "x", expected: "-2 * x^(-3)"},
+		{input: "(x + 1)^2", variable: "x", expected: "2 * (x + 1)"},
+		{input: "x / (1 + x^2)", variable: "x", expected: "(1 + x^2 - x * (2 * x)) / (1 + x^2)^2"},
+		{input: "2^x", variable: "x", expected: "2^x * log(2)"},
+		{input: "E^(2 * x)", variable: "x", expected: "E^(2 * x) * 2"},
+		{input: "x^x", variable: "x", expected: "x^x * (log(x) + 1)"},
+		{input: "-cos(x)", variable: "x", expected: "sin(x)"},
+		{input: "sqrt(x^2 + 1)", variable: "x", expected: "1 / (2 * sqrt(x^2 + 1)) * (2 * x)"},
+		{input: "x * y + y", variable: "y", expected: "x + 1"},
+		{input: "x > 1 ? x^2 : -x", variable: "x", expected: "x > 1 ? 2 * x : -1"},
+		{input: "abs(x) + 50%", variable: "x", expected: "x / abs(x)"},
+		{input: "x % 2", variable: "x", expected: "1"},
+		{input: "y", variable: "x", expected: "0"},
+		{input: "f(x)", variable: "x", err: "cannot differentiate f(x): no derivative of f()"},
+		{input: "x!", variable: "x", err: "cannot differentiate x!"},
+		{input: "sin(x, 1)", variable: "x", err: "sin() requires 1 arg"},
+		{input: "let a = x in a", variable: "x", err: "cannot differentiate let a = x in a"},
+	}
+
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		if !assert.NoError(t, err, test.input) {
+			continue
+		}
+
+		derivative, err := Derive(expr, test.variable)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.input)
+			continue
+		}
+
+		if assert.NoError(t, err, test.input) {
+			assert.Equal(t, test.expected, derivative.String(), test.input)
+		}
+	}
+
+	// derivatives agree with automatic differentiation
+	scope := NewScope(MathContext())
+	scope.SetVar("x", 0.7)
+	for _, input := range []string{"x / (1 + x^2)", "sin(x)^3 * cos(2 * x)", "(x^2 + 1)^0.5 - 3 / x"} {
+		expr, err := Parse(input)
+		assert.NoError(t, err)
+		derivative, err := Derive(expr, "x")
+		assert.NoError(t, err)
+
+		expected, err := derivative.Eval(scope)
+		assert.NoError(t, err)
+		_, grad, err := EvalGrad(expr, scope, []string{"x"})
+		assert.NoError(t, err)
+		assert.InDelta(t, expected, grad[0], 1e-12, input)
+	}
+
+	RegisterSymbolicDerivative("tan", func(args []Expression) ([]Expression, error) {
+		return []Expression{BinaryOp{Op: "/", Left: Number{Value: 1}, Right: BinaryOp{
+			Op: "^", Left: FunctionCall{Name: "cos", Args: args}, Right: Number{Value: 2}}}}, nil
+	})
+	defer delete(symbolicDerivatives, "tan")
+
+	expr, err := Parse("tan(x^2)")
+	assert.NoError(t, err)
+	derivative, err := Derive(expr, "x")
+	assert.NoError(t, err)
+	assert.Equal(t, "1 / cos(x^2)^2 * (2 * x)", derivative.String())
+}
+
+func TestSimplify(t *testing.T) {
+	all := SimplifyOptions{FoldFuncs: MathContext(), Impure: []string{"rand"}, IgnoreNaN: true, CollectTerms: true}
+
+	tests := []struct {
+		input    string
+		opts     SimplifyOptions
+		expected string
+	}{
+		{input: "2 * (3 + 4) * x", expected: "14 * x"},
+		{input: "(x * 1 + 0) / 1", expected: "x"},
+		{input: "((x))^1 - 0", expected: "x"},
+		{input: "--x + +y", expected: "x + y"},
+		{input: "x - -y", expected: "x + y"},
+		{input: "0 - x^0", expected: "-x^0"},
+		{input: "0 - x^0", opts: SimplifyOptions{IgnoreNaN: true}, expected: "-1"},
+		{input: "2^0 + (y / 0)^0 + sin(y / 0)^0", expected: "1 + (y / 0)^0 + sin(y / 0)^0"},
+		{input: "3! + 5% * x", expected: "6 + 0.05 * x"},
+		{input: "1 < 2 ? x : y", expected: "x"},
+		{input: "[1 + 1, (x)]", expected: "[2, x]"},
+		{input: "let a = 2 * 3 in a * x", expected: "let a = 6 in a * x"},
+		{input: "f(x) = (x + 0) * 1", expected: "f(x) = x"},
+		{input: "1 / 0 + x", expected: "1 / 0 + x"},
+		{input: "x * 0 + x / x - x + x", expected: "x * 0 + x / x - x + x"},
+		{input: "x * 0 + x / x - x + x", opts: SimplifyOptions{IgnoreNaN: true}, expected: "1 - x + x"},
+		{input: "0 / x + (y - y)", opts: SimplifyOptions{IgnoreNaN: true}, expected: "0"},
+		{input: "sin(0) + x", expected: "sin(0) + x"},
+		{input: "sin(0) + x", opts: all, expected: "x"},
+		{input: "2 * x + y - x + 1 + 2", opts: all, expected: "x + y + 3"},
+		{input: "x - 2 * x", opts: all, expected: "-x"},
+		{input: "2 * y * x * 3 * x", opts: all, expected: "6 * y * x^2"},
+		{input: "x * x^-1", opts: SimplifyOptions{CollectTerms: true}, expected: "x * x^(-1)"},
+		{input: "x * x^-1", opts: all, expected: "1"},
+		{input: "x^0.5 * x^0.5", opts: SimplifyOptions{CollectTerms: true}, expected: "x^0.5 * x^0.5"},
+		{input: "x^0.5 * x^0.5", opts: all, expected: "x"},
+		{input: "x^-1 * x^2", opts: SimplifyOptions{CollectTerms: true}, expected: "x^(-1) * x^2"},
+		{input: "x^-1 * x^2", opts: all, expected: "x"},
+		{input: "x * 2 * x^2", opts: SimplifyOptions{CollectTerms: true}, expected: "2 * x^3"},
+		{input: "x - x", opts: SimplifyOptions{CollectTerms: true}, expected: "0 * x"},
+		{input: "rand() - rand() + rand(1 + 1)", opts: all, expected: "rand() - rand() + rand(2)"},
+	}
+
+	for _, test := range tests {
+		prog, err := ParseProgram(test.input)
+		if !assert.NoError(t, err, test.input) {
+			continue
+		}
+
+		simplified := Simplify(prog.Statements[0], test.opts)
+		assert.Equal(t, test.expected, simplified.String(), test.input)
+	}
+
+	// simplified expressions evaluate the same
+	scope := NewScope(MathContext())
+	scope.SetVar("x", 1.5)
+	scope.SetVar("y", -4)
+	for _, input := range []string{"2 * (3 + 4) * x", "(x - 1)^2 * -(-y) / 1", "x > 1 ? y^0 * x : 2", "x * x * 3 - x + 2 * x"} {
+		expr, err := Parse(input)
+		assert.NoError(t, err)
+		expected, err := expr.Eval(scope)
+		assert.NoError(t, err)
+		result, err := Simplify(expr, all).Eval(scope)
+		assert.NoError(t, err)
+		assert.InDelta(t, expected, result, 1e-12, input)
+	}
+
+	// errors of operands are kept
+	for _, input := range []string{"(y / 0)^0", "z^0"} {
+		expr, err := Parse(input)
+		assert.NoError(t, err)
+		_, expected := expr.Eval(scope)
+		_, err = Simplify(expr, SimplifyOptions{CollectTerms: true}).Eval(scope)
+		assert.Error(t, err, input)
+		assert.Equal(t, expected, err, input)
+	}
+
+	// nodes keep the spans of those they replace
+	expr, err := Parse("(1 + 2) * x")
+	assert.NoError(t, err)
+	assert.Equal(t, expr.Span(), Simplify(expr, SimplifyOptions{}).Span())
+}
+
+func TestVars(t *testing.T) {
+	tests := []struct {
+		input string
+		vars  []string
+		funcs []string
+	}{
+		{input: "x + y * sin(z) - x", vars: []string{"x", "y", "z"}, funcs: []string{"sin"}},
+		{input: "2 + 3", vars: nil, funcs: nil},
+		{input: "let u = x, v = u + w in v * u", vars: []string{"x", "w"}, funcs: nil},
+		{input: "apply(a -> a * b, c)", vars: []string{"b", "c"}, funcs: []string{"apply"}},
+		{input: "let f = n -> n + k in f(1) + g(2)", vars: []string{"k"}, funcs: []string{"g"}},
+		{input: "let f = n -> n < 1 ? 1 : n * f(n - 1) in f(5)", vars: nil, funcs: nil},
+		{input: "apply(sin, x)", vars: []string{"sin", "x"}, funcs: []string{"apply"}},
+		{input: "m.a + m.f(1)", vars: []string{"m.a"}, funcs: []string{"m.f"}},
+	}
+
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		if !assert.NoError(t, err, test.input) {
+			continue
+		}
+
+		assert.Equal(t, test.vars, Vars(expr), test.input)
+		assert.Equal(t, test.funcs, Funcs(expr), test.input)
+	}
+
+	// definitions bind their names and parameters
+	prog, err := ParseProgram("f(a) = a * b + f(a - 1)")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b"}, Vars(prog.Statements[0]))
+	assert.Nil(t, Funcs(prog.Statements[0]))
+}
+
+func TestCheck(t *testing.T) {
+	ctx := NewScope(MathContext())
+	prog, err := ParseProgram("x = 1; f(a, b) = a * b; g = f; h = n -> n; r = round")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = prog.Run(ctx)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{input: "x + sin(Pi) * f(1, 2) + h(3)", expected: nil},
+		{input: "let fact = n -> n < 1 ? 1 : n * fact(n - 1) in fact(5) + fact(1, 2)", expected: []string{"fact() requires 1 arg, got 2"}},
+		{input: "and() + or(x, 0, 1) + and(1)", expected: nil},
+		{input: "sum([x]) + sum(n -> n, 1, 2) + sum(n -> n, 1) + sum()", expected: []string{
+			"sum() requires 1 or 3 args, got 2", "sum() requires 1 or 3 args, got 0"}},
+		{input: "apply(sin, x) + sum(n -> n^2, 1, 3) + round(x, 2) + r(x)", expected: nil},
+		{input: "y + z(1) + y", expected: []string{"variable not specified: y", "function not specified: z", "variable not specified: y"}},
+		{input: "sin(1, 2) + f(1) + g() + h(1, 2)", expected: []string{
+			"sin() requires 1 arg, got 2", "f() requires 2 args, got 1", "g() requires 2 args, got 0", "h() requires 1 arg, got 2"}},
+		{input: "round(1, 2, 3) + r() + mean() + dot(x)", expected: []string{
+			"round() requires 1 or 2 args, got 3", "r() requires 1 or 2 args, got 0", "mean() requires at least 1 arg, got 0", "dot() requires 2 args, got 1"}},
+		{input: "let k = n -> n + y in k(1, 2)", expected: []string{"variable not specified: y", "k() requires 1 arg, got 2"}},
+		{input: "let f = (a, b, c) -> a in f(1, 2, 3) + sin(a)", expected: []string{"variable not specified: a"}},
+	}
+
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		if !assert.NoError(t, err, test.input) {
+			continue
+		}
+
+		var messages []string
+		for _, err := range Check(expr, ctx) {
+			messages = append(messages, err.Error())
+		}
+
+		assert.Equal(t, test.expected, messages, test.input)
+	}
+
+	// arities can be declared for functions defined in Go
+	ctx.SetFunc("clamp", func(args []float64) (float64, error) { return math.Max(args[1], math.Min(args[0], args[2])), nil })
+	ctx.SetArity("clamp", Arity{Min: 3, Max: 3})
+	expr, err := Parse("clamp(x, 0)")
+	assert.NoError(t, err)
+	assert.EqualError(t, Check(expr, ctx)[0], "clamp() requires 3 args, got 2")
+
+	assert.Equal(t, "0, 2 or 4 args", Arity{Counts: []int{0, 2, 4}}.String())
+	assert.False(t, Arity{Counts: []int{0, 2, 4}}.Accepts(3))
+
+	// redefining a function forgets its arity
+	ctx.SetFunc("clamp", func(args []float64) (float64, error) { return args[0], nil })
+	assert.Nil(t, Check(expr, ctx))
+}
+
+type money struct {
+	cents int64
+}
+
+const kindMoney Kind = "money"
+
+func (m money) Kind() Kind { return kindMoney }
+
+func (m money) String() string { return fmt.Sprintf("$%d.%02d", m.cents/100, m.cents%100) }
+
+func TestEvalCustomValues(t *testing.T) {
+	RegisterInfixValue("+", kindMoney, kindMoney, func(l, r Value) (Value, error) {
+		return money{l.(money).cents + r.(money).cents}, nil
+	})
+	RegisterInfixValue("*", kindMoney, KindNumber, func(l, r Value) (Value, error) {
+		return money{int64(math.Round(float64(l.(money).cents) * float64(r.(Float))))}, nil
+	})
+	RegisterInfixValue(">", kindMoney, kindMoney, func(l, r Value) (Value, error) {
+		return Bool(l.(money).cents > r.(money).cents), nil
+	})
+	RegisterPrefixValue("-", kindMoney, func(x Value) (Value, error) {
+		return money{-x.(money).cents}, nil
+	})
+	defer func() {
+		delete(infixValueOps, infixKey{"+", kindMoney, kindMoney})
+		delete(infixValueOps, infixKey{"*", kindMoney, KindNumber})
+		delete(infixValueOps, infixKey{">", kindMoney, kindMoney})
+		delete(unaryValueOps, unaryKey{"-", false, kindMoney})
+	}()
+
+	ctx := MathContext()
+	ctx.SetValue("price", money{1999})
+	ctx.SetValue("shipping", money{500})
+	ctx.SetValue("name", String("calculon"))
+	ctx.SetValue("ok", Bool(true))
+	ctx.SetValueFunc("usd", func(args []Value) (Value, error) {
+		f, ok := args[0].(Float)
+		if !ok {
+			return nil, fmt.Errorf("usd(): not a number: %s", args[0])
+		}
+
+		return money{int64(math.Round(float64(f) * 100))}, nil
+	})
+
+	tests := []struct {
+		input    string
+		expected Value
+		err      string
+	}{
+		{input: "price * 3 + shipping", expected: money{6497}},
+		{input: "-price", expected: money{-1999}},
+		{input: "usd(0.1) * 3", expected: money{30}},
+		{input: "price > usd(20) ? 1 : 0", expected: Float(0)},
+		{input: "if(price > shipping, price, shipping)", expected: money{1999}},
+		{input: "total(items, fee) = items + fee; total(price, shipping)", expected: money{2499}},
+		{input: "double = x -> x * 2; [double(1), double(price)][0]", err: "vector element is not a number: double(price)"},
+		{input: "name + name == name", expected: Bool(false)},
+		{input: "name + name", expected: String("calculoncalculon")},
+		{input: "ok && !ok", expected: Bool(false)},
+		{input: "ok || x", expected: Bool(true)},
+		{input: "ok ? name : 0", expected: String("calculon")},
+		{input: "price - shipping", err: "operator - is not defined for money and money"},
+		{input: "price * price", err: "operator * is not defined for money and money"},
+		{input: "!name", err: "operator ! is not defined for string"},
+		{input: "name ? 1 : 0", err: "string used as a condition: \"calculon\""},
+		{input: "sin(price)", err: "money used as a number: $19.99"},
+	}
+
+	for _, test := range tests {
+		prog, err := ParseProgram(test.input)
+		if !assert.NoError(t, err, test.input) {
+			continue
+		}
+
+		result, err := prog.RunValue(NewScope(ctx))
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.input)
+			continue
+		}
+
+		assert.NoError(t, err, test.input)
+		assert.Equal(t, test.expected, result, test.input)
+	}
+
+	assert.Panics(t, func() { RegisterInfixValue("@", kindMoney, kindMoney, nil) })
+}
+
+func TestRegisterOperators(t *testing.T) {
+	RegisterInfix("<<", PrecSum-5, LeftAssoc, func(l, r float64) (float64, error) {
+		return l * math.Pow(2, r), nil
+	})
+	RegisterInfix("..", PrecComparison+5, LeftAssoc, func(l, r float64) (float64, error) {
+		return r - l + 1, nil
+	})
+	RegisterInfix("mod", PrecTerm, LeftAssoc, func(l, r float64) (float64, error) {
+		return math.Mod(l, r), nil
+	})
+	RegisterPrefix("±", PrecPrefix, func(x float64) (float64, error) {
+		return math.Abs(x), nil
+	})
+	defer func() {
+		delete(infixOps, "<<")
+		delete(infixOps, "..")
+		delete(infixOps, "mod")
+		delete(prefixOps, "±")
+		updateOperatorSymbols()
+	}()
+
+	tests := []struct {
+		input    string
+		expected float64
+		str      string
+	}{
+		{input: "1 << 2 + 1", expected: 8, str: "1 << 2 + 1"},
+		{input: "(1 << 2) + 1", expected: 5, str: "(1 << 2) + 1"},
+		{input: "1..5", expected: 5, str: "1 .. 5"},
+		{input: "2 * 3..4 == 5", expected: 0, str: "2 * 3 .. 4 == 5"},
+		{input: "7 mod 4 * 2", expected: 6, str: "7 mod 4 * 2"},
+		{input: "±-3 + 1", expected: 4, str: "±-3 + 1"},
+		{input: "1 < 2", expected: 1, str: "1 < 2"},
+	}
+
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		if !assert.NoError(t, err, test.input) {
+			continue
+		}
+
+		result, err := expr.Eval(EmptyContext{})
+		assert.NoError(t, err, test.input)
+		assert.Equal(t, test.expected, result, test.input)
+		assert.Equal(t, test.str, expr.String(), test.input)
+	}
+
+	assert.Panics(t, func() { RegisterInfix("a+", PrecSum, LeftAssoc, nil) })
+	assert.Panics(t, func() { RegisterPrefix("(", PrecPrefix, nil) })
+}
@@ -3,122 +3,285 @@ package calculon
 import (
 	"fmt"
 	"math"
-	"strconv"
 	"strings"
 )
 
 type Expression interface {
+	// Eval evaluates the expression to a number.
 	Eval(ctx EvalContext) (float64, error)
+	// EvalValue evaluates the expression to a value of any kind, such as a Vector.
+	EvalValue(ctx EvalContext) (Value, error)
 	String() string
+	// Span returns the part of the input the expression was parsed from.
+	Span() Span
+	// Children returns the subexpressions in the order they appear in, see Walk.
+	Children() []Expression
 }
 
 type Number struct {
 	Value float64
+	// Literal is the number as spelled in the input, if it was parsed from one.
+	Literal string
+	Loc     Span
 }
 
 func (c Number) Eval(ctx EvalContext) (float64, error) {
 	return c.Value, nil
 }
 
+func (c Number) EvalValue(ctx EvalContext) (Value, error) {
+	return numberValue(ctx, c.Literal, c.Value)
+}
+
 func (c Number) String() string {
-	return strconv.FormatFloat(c.Value, 'g', 10, 64)
+	if c.Literal != "" {
+		return c.Literal
+	}
+
+	return formatFloat(c.Value)
+}
+
+func (c Number) Span() Span {
+	return c.Loc
+}
+
+func (Number) Children() []Expression {
+	return nil
+}
+
+// Imaginary is an imaginary number literal: 2i.
+type Imaginary struct {
+	Value float64 // the coefficient of i
+	// Literal is the number as spelled in the input, if it was parsed from one.
+	Literal string
+	Loc     Span
+}
+
+func (im Imaginary) Eval(ctx EvalContext) (float64, error) {
+	return number(im.EvalValue(ctx))
+}
+
+func (im Imaginary) EvalValue(ctx EvalContext) (Value, error) {
+	return Complex(complex(0, im.Value)), nil
+}
+
+func (im Imaginary) String() string {
+	if im.Literal != "" {
+		return im.Literal
+	}
+
+	return formatFloat(im.Value) + "i"
+}
+
+func (im Imaginary) Span() Span {
+	return im.Loc
+}
+
+func (Imaginary) Children() []Expression {
+	return nil
 }
 
 type BinaryOp struct {
 	Op    string
 	Left  Expression
 	Right Expression
+	Loc   Span
 }
 
 func (binary BinaryOp) Eval(ctx EvalContext) (float64, error) {
-	l, err := binary.Left.Eval(ctx)
-	if err != nil {
-		return 0, err
-	}
+	return number(binary.EvalValue(ctx))
+}
 
-	r, err := binary.Right.Eval(ctx)
+func (binary BinaryOp) EvalValue(ctx EvalContext) (Value, error) {
+	l, err := binary.Left.EvalValue(ctx)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	switch binary.Op {
-	case "+":
-		return l + r, nil
-	case "-":
-		return l - r, nil
-	case "*":
-		return l * r, nil
-	case "/":
-		if r == 0 {
-			return 0, fmt.Errorf("divide by zero")
+	// logical operators do not evaluate the right side if the left one decides
+	if b, ok := l.(Bool); ok {
+		if binary.Op == "&&" && !b || binary.Op == "||" && b {
+			return b, nil
+		}
+	} else if f, ok := realValue(l); ok {
+		// the result is a number of the kind ctx evaluates numbers to
+		if binary.Op == "&&" && f == 0 {
+			return numberValue(ctx, "", 0)
+		}
+
+		if binary.Op == "||" && f != 0 {
+			return numberValue(ctx, "", 1)
 		}
+	}
 
-		return l / r, nil
-	case "%":
-		return math.Mod(l, r), nil
-	case "^":
-		return math.Pow(l, r), nil
-	default:
-		return 0, fmt.Errorf("unexpected binary op: %s", binary.Op)
+	r, err := binary.Right.EvalValue(ctx)
+	if err != nil {
+		return nil, err
 	}
+
+	return evalInfix(binary.Op, l, r)
 }
 
 func (binary BinaryOp) String() string {
+	op, found := infixOps[binary.Op]
+	if !found {
+		op.power = precPrimary
+	}
+
+	leftPower, rightPower := op.power, op.power+1
+	if op.assoc == RightAssoc {
+		leftPower, rightPower = op.power+1, op.power
+	}
+
+	left := parenthesize(binary.Left, leftPower)
+
+	// a tight prefix operator needs no parens on the right: 2^-x
+	right := binary.Right.String()
+	if p := precedence(binary.Right); p < rightPower && !isTightPrefix(binary.Right) {
+		right = "(" + right + ")"
+	}
+
 	if binary.Op == "^" {
-		return binary.Left.String() + binary.Op + binary.Right.String()
+		return left + binary.Op + right
 	}
 
-	return binary.Left.String() + " " + binary.Op + " " + binary.Right.String()
+	return left + " " + binary.Op + " " + right
+}
+
+func (binary BinaryOp) Span() Span {
+	return binary.Loc
+}
+
+func (binary BinaryOp) Children() []Expression {
+	return []Expression{binary.Left, binary.Right}
 }
 
 type UnaryOp struct {
 	Op        string
 	Expr      Expression
 	IsPostfix bool
+	Loc       Span
 }
 
 func (unary UnaryOp) Eval(ctx EvalContext) (float64, error) {
-	val, err := unary.Expr.Eval(ctx)
+	return number(unary.EvalValue(ctx))
+}
+
+func (unary UnaryOp) EvalValue(ctx EvalContext) (Value, error) {
+	val, err := unary.Expr.EvalValue(ctx)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	switch unary.Op {
-	case "-":
-		return -val, nil
-	default:
-		return 0, fmt.Errorf("unexpected unary op: %s", unary.Op)
-	}
+	return evalUnary(unary.Op, unary.IsPostfix, val)
 }
 
 func (unary UnaryOp) String() string {
 	if unary.IsPostfix {
-		return unary.Expr.String() + unary.Op
+		return parenthesize(unary.Expr, precedence(unary)) + unary.Op
 	}
 
-	return unary.Op + unary.Expr.String()
+	operand := parenthesize(unary.Expr, precedence(unary))
+	if isWordOperator(unary.Op) {
+		return unary.Op + " " + operand
+	}
+
+	return unary.Op + operand
+}
+
+func (unary UnaryOp) Span() Span {
+	return unary.Loc
+}
+
+func (unary UnaryOp) Children() []Expression {
+	return []Expression{unary.Expr}
+}
+
+// Conditional is the ternary operator c ? a : b.
+// Only the branch selected by the condition is evaluated.
+type Conditional struct {
+	Cond Expression
+	Then Expression
+	Else Expression
+	Loc  Span
+}
+
+func (cond Conditional) Eval(ctx EvalContext) (float64, error) {
+	return number(cond.EvalValue(ctx))
+}
+
+func (cond Conditional) EvalValue(ctx EvalContext) (Value, error) {
+	val, err := cond.Cond.EvalValue(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := truthy(val)
+	if err != nil {
+		return nil, err
+	}
+
+	if c {
+		return cond.Then.EvalValue(ctx)
+	}
+
+	return cond.Else.EvalValue(ctx)
+}
+
+func (cond Conditional) String() string {
+	return parenthesize(cond.Cond, PrecConditional+1) + " ? " + cond.Then.String() + " : " + cond.Else.String()
+}
+
+func (cond Conditional) Span() Span {
+	return cond.Loc
+}
+
+func (cond Conditional) Children() []Expression {
+	return []Expression{cond.Cond, cond.Then, cond.Else}
 }
 
 type Parentheses struct {
 	Expr Expression
+	Loc  Span
 }
 
 func (paren Parentheses) Eval(ctx EvalContext) (float64, error) {
 	return paren.Expr.Eval(ctx)
 }
 
+func (paren Parentheses) EvalValue(ctx EvalContext) (Value, error) {
+	return paren.Expr.EvalValue(ctx)
+}
+
 func (paren Parentheses) String() string {
 	return "(" + paren.Expr.String() + ")"
 }
 
+func (paren Parentheses) Span() Span {
+	return paren.Loc
+}
+
+func (paren Parentheses) Children() []Expression {
+	return []Expression{paren.Expr}
+}
+
 type Variable struct {
 	Name string
+	Loc  Span
 }
 
 func (vb Variable) Eval(ctx EvalContext) (float64, error) {
-	value, found := ctx.LookupVar(vb.Name)
+	return number(vb.EvalValue(ctx))
+}
+
+func (vb Variable) EvalValue(ctx EvalContext) (Value, error) {
+	value, found := lookupValue(ctx, vb.Name)
 	if !found {
-		return 0, fmt.Errorf("variable not specified: %s", vb)
+		return nil, fmt.Errorf("variable not specified: %s", vb)
+	}
+
+	if f, ok := value.(Float); ok {
+		return numberValue(ctx, "", float64(f))
 	}
 
 	return value, nil
@@ -128,35 +291,566 @@ func (vb Variable) String() string {
 	return vb.Name
 }
 
+func (vb Variable) Span() Span {
+	return vb.Loc
+}
+
+func (Variable) Children() []Expression {
+	return nil
+}
+
 type FunctionCall struct {
 	Name string
 	Args []Expression
+	Loc  Span
 }
 
 func (call FunctionCall) Eval(ctx EvalContext) (float64, error) {
-	fn, found := ctx.LookupFunc(call.Name)
+	return number(call.EvalValue(ctx))
+}
+
+func (call FunctionCall) EvalValue(ctx EvalContext) (Value, error) {
+	fn, found := lookupAnyFunc(ctx, call.Name)
 	if !found {
-		return 0, fmt.Errorf("function not specified: %s", call.Name)
+		return nil, fmt.Errorf("function not specified: %s", call.Name)
 	}
 
-	args := make([]float64, 0, len(call.Args))
-	for _, arg := range call.Args {
-		n, err := arg.Eval(ctx)
+	return fn(ctx, call.Args)
+}
+
+func (call FunctionCall) String() string {
+	return call.Name + "(" + joinArgs(call.Args) + ")"
+}
+
+func (call FunctionCall) Span() Span {
+	return call.Loc
+}
+
+func (call FunctionCall) Children() []Expression {
+	return call.Args
+}
+
+// List is a vector literal: [1, 2, x + 1].
+type List struct {
+	Items []Expression
+	Loc   Span
+}
+
+func (list List) Eval(ctx EvalContext) (float64, error) {
+	return number(list.EvalValue(ctx))
+}
+
+func (list List) EvalValue(ctx EvalContext) (Value, error) {
+	vec := make(Vector, 0, len(list.Items))
+	for _, item := range list.Items {
+		val, err := item.EvalValue(ctx)
 		if err != nil {
-			return 0, err
+			return nil, err
+		}
+
+		f, ok := realValue(val)
+		if !ok {
+			return nil, fmt.Errorf("vector element is not a number: %s", item)
 		}
 
-		args = append(args, n)
+		vec = append(vec, float64(f))
 	}
 
-	return fn(args)
+	return vec, nil
 }
 
-func (call FunctionCall) String() string {
-	var args []string
-	for _, arg := range call.Args {
-		args = append(args, arg.String())
+func (list List) String() string {
+	return "[" + joinArgs(list.Items) + "]"
+}
+
+func (list List) Span() Span {
+	return list.Loc
+}
+
+func (list List) Children() []Expression {
+	return list.Items
+}
+
+// Index picks an element of a vector: v[0] is the first one.
+type Index struct {
+	Expr  Expression
+	Index Expression
+	Loc   Span
+}
+
+func (index Index) Eval(ctx EvalContext) (float64, error) {
+	return number(index.EvalValue(ctx))
+}
+
+func (index Index) EvalValue(ctx EvalContext) (Value, error) {
+	val, err := index.Expr.EvalValue(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	vec, ok := val.(Vector)
+	if !ok {
+		return nil, fmt.Errorf("cannot index %s: not a vector", index.Expr)
+	}
+
+	i, err := index.Index.Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if i != math.Trunc(i) {
+		return nil, fmt.Errorf("vector index is not an integer: %v", i)
+	}
+
+	if i < 0 || i >= float64(len(vec)) {
+		return nil, fmt.Errorf("vector index out of range: %v with length %d", i, len(vec))
+	}
+
+	return Float(vec[int(i)]), nil
+}
+
+func (index Index) String() string {
+	return parenthesize(index.Expr, precPrimary) + "[" + index.Index.String() + "]"
+}
+
+func (index Index) Span() Span {
+	return index.Loc
+}
+
+func (index Index) Children() []Expression {
+	return []Expression{index.Expr, index.Index}
+}
+
+// Assignment binds a variable: x = 2 * y.
+// It evaluates to the assigned value.
+type Assignment struct {
+	Name  string
+	Value Expression
+	Loc   Span
+}
+
+func (assign Assignment) Eval(ctx EvalContext) (float64, error) {
+	return number(assign.EvalValue(ctx))
+}
+
+func (assign Assignment) EvalValue(ctx EvalContext) (Value, error) {
+	mutable, ok := ctx.(MutableContext)
+	if !ok {
+		return nil, fmt.Errorf("cannot assign %s: context is read-only", assign.Name)
+	}
+
+	// f = x -> x^2 and g = sin define functions
+	if fn, ok := funcValue(ctx, assign.Value); ok {
+		mutable.SetLazyValueFunc(assign.Name, fn)
+		if arity, ok := funcArity(ctx, assign.Value); ok {
+			if arityctx, ok := mutable.(arityMutable); ok {
+				arityctx.SetArity(assign.Name, arity)
+			}
+		}
+
+		return Float(0), nil
+	}
+
+	val, err := assign.Value.EvalValue(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mutable.SetValue(assign.Name, val)
+	return val, nil
+}
+
+func (assign Assignment) String() string {
+	return assign.Name + " = " + assign.Value.String()
+}
+
+func (assign Assignment) Span() Span {
+	return assign.Loc
+}
+
+func (assign Assignment) Children() []Expression {
+	return []Expression{assign.Value}
+}
+
+// FunctionDef defines a function: f(a, b) = a^2 + b.
+// It is the same as f = (a, b) -> a^2 + b, see Lambda. A definition evaluates to 0.
+type FunctionDef struct {
+	Name   string
+	Params []string
+	Body   Expression
+	Loc    Span
+}
+
+func (def FunctionDef) Eval(ctx EvalContext) (float64, error) {
+	return number(def.EvalValue(ctx))
+}
+
+func (def FunctionDef) EvalValue(ctx EvalContext) (Value, error) {
+	mutable, ok := ctx.(MutableContext)
+	if !ok {
+		return nil, fmt.Errorf("cannot define %s: context is read-only", def.Name)
+	}
+
+	mutable.SetLazyValueFunc(def.Name, closure(def.signature(), def.Params, def.Body, ctx))
+	if arityctx, ok := mutable.(arityMutable); ok {
+		arityctx.SetArity(def.Name, Arity{Min: len(def.Params), Max: len(def.Params)})
+	}
+
+	return Float(0), nil
+}
+
+func (def FunctionDef) String() string {
+	return def.signature() + " = " + def.Body.String()
+}
+
+func (def FunctionDef) signature() string {
+	return def.Name + "(" + strings.Join(def.Params, ", ") + ")"
+}
+
+func (def FunctionDef) Span() Span {
+	return def.Loc
+}
+
+func (def FunctionDef) Children() []Expression {
+	return []Expression{def.Body}
+}
+
+// Lambda is an anonymous function: x -> x^2, (a, b) -> a * b.
+// It is not a number, but it can be assigned to a name, f = x -> x^2,
+// or passed to a function taking functions, apply(x -> x^2, 3).
+type Lambda struct {
+	Params []string
+	Body   Expression
+	Loc    Span
+}
+
+func (lambda Lambda) Eval(ctx EvalContext) (float64, error) {
+	return number(lambda.EvalValue(ctx))
+}
+
+func (lambda Lambda) EvalValue(ctx EvalContext) (Value, error) {
+	return nil, fmt.Errorf("function used as a number: %s", lambda)
+}
+
+func (lambda Lambda) String() string {
+	if len(lambda.Params) == 1 {
+		return lambda.Params[0] + " -> " + lambda.Body.String()
+	}
+
+	return "(" + strings.Join(lambda.Params, ", ") + ") -> " + lambda.Body.String()
+}
+
+func (lambda Lambda) Span() Span {
+	return lambda.Loc
+}
+
+func (lambda Lambda) Children() []Expression {
+	return []Expression{lambda.Body}
+}
+
+// Closure turns the lambda into a function whose body sees the names of ctx,
+// shadowed by the parameters.
+func (lambda Lambda) Closure(ctx EvalContext) LazyValueFunction {
+	return closure("lambda("+strings.Join(lambda.Params, ", ")+")", lambda.Params, lambda.Body, ctx)
+}
+
+// MaxCallDepth limits how deep calls of user functions may nest,
+// so that runaway recursion fails with a *StackOverflowError.
+// It is the default for contexts without a limit of their own, see
+// Scope.SetMaxCallDepth. It is read during evaluation, so it must only be
+// changed before evaluation starts.
+var MaxCallDepth = 1000
+
+// closure returns a function evaluating body in ctx with the parameters bound,
+// in the evaluation mode of the caller, see ComplexMode: numbers and builtins
+// mean in the body what they mean where the function is called.
+// Arguments are evaluated by the caller, except those that stand for functions,
+// which are bound as functions: twice(f, x) = f(f(x)) works with twice(sin, 1).
+//
+// Every call gets a frame of its own, so calls may nest and recurse,
+// and run concurrently as long as nobody defines names in ctx meanwhile.
+func closure(name string, params []string, body Expression, ctx EvalContext) LazyValueFunction {
+	return func(caller EvalContext, args []Expression) (Value, error) {
+		if len(args) != len(params) {
+			return nil, fmt.Errorf("%s: bad params count (want %d, got %d)", name, len(params), len(args))
+		}
+
+		depth := callDepth(caller) + 1
+		limit := maxCallDepth(caller)
+		if depth > limit {
+			return nil, &StackOverflowError{Func: name, Depth: limit}
+		}
+
+		frame := NewScope(inMode(ctx, caller))
+		frame.depth = depth
+		frame.maxCallDepth = limit
+		for i, param := range params {
+			if fn, ok := funcValue(caller, args[i]); ok {
+				frame.SetLazyValueFunc(param, fn)
+				continue
+			}
+
+			val, err := args[i].EvalValue(caller)
+			if err != nil {
+				return nil, err
+			}
+
+			frame.SetValue(param, val)
+		}
+
+		return body.EvalValue(frame)
+	}
+}
+
+// funcValue returns the function expr stands for, if it is a lambda
+// or the name of a function rather than of a variable.
+func funcValue(ctx EvalContext, expr Expression) (LazyValueFunction, bool) {
+	switch expr := expr.(type) {
+	case Parentheses:
+		return funcValue(ctx, expr.Expr)
+	case Lambda:
+		return expr.Closure(ctx), true
+	case Variable:
+		if _, found := lookupValue(ctx, expr.Name); found {
+			return nil, false
+		}
+
+		return lookupAnyFunc(ctx, expr.Name)
+	}
+
+	return nil, false
+}
+
+// lookupAnyFunc looks up a function of any kind and adapts it to a LazyValueFunction.
+func lookupAnyFunc(ctx EvalContext, name string) (LazyValueFunction, bool) {
+	if fn, found := lookupValueFunc(ctx, name); found {
+		return fn, true
+	}
+
+	if fn, found := lookupLazyFunc(ctx, name); found {
+		return valued(fn), true
+	}
+
+	if fn, found := lookupFunc(ctx, name); found {
+		return valued(eager(fn)), true
+	}
+
+	return nil, false
+}
+
+// valued adapts a function returning numbers to return values.
+func valued(fn LazyFunction) LazyValueFunction {
+	return func(ctx EvalContext, args []Expression) (Value, error) {
+		val, err := fn(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+
+		return Float(val), nil
+	}
+}
+
+// eagerValue adapts fn to be called with unevaluated arguments.
+func eagerValue(fn ValueFunction) LazyValueFunction {
+	return func(ctx EvalContext, args []Expression) (Value, error) {
+		vals := make([]Value, 0, len(args))
+		for _, arg := range args {
+			val, err := arg.EvalValue(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			vals = append(vals, val)
+		}
+
+		return fn(vals)
+	}
+}
+
+// eager adapts fn to be called with unevaluated arguments.
+func eager(fn Function) LazyFunction {
+	return func(ctx EvalContext, args []Expression) (float64, error) {
+		vals := make([]float64, 0, len(args))
+		for _, arg := range args {
+			n, err := arg.Eval(ctx)
+			if err != nil {
+				return 0, err
+			}
+
+			vals = append(vals, n)
+		}
+
+		return fn(vals)
+	}
+}
+
+// Let evaluates its body with local bindings: let d = sqrt(x^2 + y^2) in d/(1+d),
+// or with the bindings written after the body: d/(1+d) where d = sqrt(x^2 + y^2).
+// Bindings are assignments and function definitions, each sees the ones before it.
+// They go to a child scope, so they never leak into the context.
+type Let struct {
+	Bindings []Expression
+	Body     Expression
+	Where    bool // written as body where bindings
+	Loc      Span
+}
+
+func (let Let) Eval(ctx EvalContext) (float64, error) {
+	return number(let.EvalValue(ctx))
+}
+
+func (let Let) EvalValue(ctx EvalContext) (Value, error) {
+	scope := NewScope(ctx)
+	for _, binding := range let.Bindings {
+		if _, err := binding.EvalValue(scope); err != nil {
+			return nil, err
+		}
+	}
+
+	return let.Body.EvalValue(scope)
+}
+
+func (let Let) String() string {
+	bindings := make([]string, 0, len(let.Bindings))
+	for _, binding := range let.Bindings {
+		bindings = append(bindings, binding.String())
+	}
+
+	if let.Where {
+		return parenthesize(let.Body, 1) + " where " + strings.Join(bindings, ", ")
+	}
+
+	return "let " + strings.Join(bindings, ", ") + " in " + let.Body.String()
+}
+
+func (let Let) Span() Span {
+	return let.Loc
+}
+
+// Children returns the bindings followed by the body, in the order they are evaluated.
+func (let Let) Children() []Expression {
+	return append(append([]Expression(nil), let.Bindings...), let.Body)
+}
+
+// Bad is a placeholder for a part of the input that failed to parse.
+// It only appears in trees returned by ParseAll and never evaluates.
+type Bad struct {
+	Loc Span
+}
+
+func (bad Bad) Eval(ctx EvalContext) (float64, error) {
+	return number(bad.EvalValue(ctx))
+}
+
+func (bad Bad) EvalValue(ctx EvalContext) (Value, error) {
+	return nil, fmt.Errorf("bad expression at %d:%d", bad.Loc.Start.Line, bad.Loc.Start.Column)
+}
+
+func (bad Bad) String() string {
+	return "<bad>"
+}
+
+func (bad Bad) Span() Span {
+	return bad.Loc
+}
+
+func (Bad) Children() []Expression {
+	return nil
+}
+
+// truth converts a boolean to a number: 1 for true and 0 for false.
+// Any non-zero number is true.
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// factorial computes x! for real x as Gamma(x+1).
+func factorial(x float64) (float64, error) {
+	if x < 0 && x == math.Trunc(x) {
+		return 0, fmt.Errorf("factorial of negative integer: %v", x)
+	}
+
+	// multiply out small integers, so that they stay exact
+	if x == math.Trunc(x) && x <= 170 {
+		result := 1.0
+		for i := 2.0; i <= x; i++ {
+			result *= i
+		}
+
+		return result, nil
+	}
+
+	return math.Gamma(x + 1), nil
+}
+
+// joinArgs prints a comma separated list of expressions.
+func joinArgs(args []Expression) string {
+	strs := make([]string, 0, len(args))
+	for _, arg := range args {
+		// bindings of a where clause would take the following arguments
+		if let, ok := arg.(Let); ok && let.Where {
+			strs = append(strs, "("+arg.String()+")")
+			continue
+		}
+
+		strs = append(strs, arg.String())
+	}
+
+	return strings.Join(strs, ", ")
+}
+
+// precedence returns how tightly the expression binds when printed.
+func precedence(expr Expression) int {
+	switch expr := expr.(type) {
+	case BinaryOp:
+		if op, found := infixOps[expr.Op]; found {
+			return op.power
+		}
+	case UnaryOp:
+		ops, power := prefixOps, PrecPrefix
+		if expr.IsPostfix {
+			ops, power = postfixOps, PrecPostfix
+		}
+
+		if op, found := ops[expr.Op]; found {
+			return op.power
+		}
+
+		return power
+	case Conditional:
+		return PrecConditional
+	case Lambda, Let:
+		// the body or bindings extend as far right as possible
+		return 0
+	case Number:
+		// printed with a sign, so it reads like a unary minus
+		if expr.Value < 0 || math.Signbit(expr.Value) {
+			return PrecPrefix
+		}
+	case Imaginary:
+		if expr.Value < 0 || math.Signbit(expr.Value) {
+			return PrecPrefix
+		}
+	}
+
+	return precPrimary
+}
+
+func isTightPrefix(expr Expression) bool {
+	unary, ok := expr.(UnaryOp)
+	return ok && !unary.IsPostfix && precedence(unary) >= PrecPrefix
+}
+
+// parenthesize prints expr in parens if it binds looser than prec,
+// so trees built without Parentheses nodes print as they evaluate.
+func parenthesize(expr Expression, prec int) string {
+	if precedence(expr) < prec {
+		return "(" + expr.String() + ")"
 	}
 
-	return call.Name + "(" + strings.Join(args, ", ") + ")"
+	return expr.String()
 }
@@ -1,144 +1,338 @@
 package lexer
 
 import (
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type Lexer struct {
-	source []rune
+	source    string
+	operators []string
+	cursor
+
+	// the token after the cursor, if it was looked at with Ahead
+	ahead      Token
+	aheadFrom  cursor
+	aheadTo    cursor
+	aheadValid bool
+}
+
+type cursor struct {
 	pos    int
-	end    bool
+	line   int
+	column int
 }
 
 func (l *Lexer) Pos() int {
 	return l.pos
 }
 
-func New(input string) *Lexer {
+// New returns a lexer of input. Besides punctuation, numbers and identifiers,
+// it recognizes the given operator symbols. They are tried in order,
+// so longer symbols must precede their prefixes: <= before <.
+func New(input string, operators ...string) *Lexer {
 	return &Lexer{
-		source: []rune(input),
-		end:    len(input) == 0,
+		source:    input,
+		operators: operators,
+		cursor:    cursor{line: 1, column: 1},
 	}
 }
 
+func (l *Lexer) position() Position {
+	return Position{
+		Offset: l.pos,
+		Line:   l.line,
+		Column: l.column,
+	}
+}
+
+func (l *Lexer) eof() bool {
+	return l.pos >= len(l.source)
+}
+
 func (l *Lexer) current() rune {
-	return l.source[l.pos]
+	r, _ := utf8.DecodeRuneInString(l.source[l.pos:])
+	return r
 }
 
 func (l *Lexer) next() bool {
-	if l.pos == len(l.source)-1 {
-		l.end = true
-		return false
+	r, size := utf8.DecodeRuneInString(l.source[l.pos:])
+	l.pos += size
+	if r == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
 	}
 
-	l.pos++
-	return true
+	return !l.eof()
 }
 
 func (l *Lexer) Next() Token {
-	if l.end {
-		return Token{Kind: EOF}
+	if l.aheadValid && l.aheadFrom == l.cursor {
+		l.cursor = l.aheadTo
+		return l.ahead
+	}
+
+	for !l.eof() && unicode.IsSpace(l.current()) {
+		l.next()
+	}
+
+	start := l.position()
+	if l.eof() {
+		return Token{Kind: EOF, Span: Span{Start: start, End: start}}
+	}
+
+	tok := l.scan()
+	tok.Span = Span{Start: start, End: l.position()}
+
+	return tok
+}
+
+func (l *Lexer) scan() Token {
+	if isDigit(l.at(0)) || (l.at(0) == '.' && isDigit(l.at(1))) {
+		return l.scanNumber()
+	}
+
+	if isIdentStart(l.current()) {
+		return l.scanIdent()
+	}
+
+	// before operators, so that it is not taken for a minus
+	if strings.HasPrefix(l.source[l.pos:], "->") {
+		l.skip(2)
+		return Token{Kind: Arrow}
 	}
 
-	for unicode.IsSpace(l.current()) {
-		if !l.next() {
-			return Token{Kind: EOF}
+	for _, op := range l.operators {
+		if strings.HasPrefix(l.source[l.pos:], op) {
+			l.skip(len(op))
+			return Token{Kind: Operator, Value: op}
 		}
 	}
 
 	switch l.current() {
-	case '+':
-		l.next()
-		return Token{Kind: Plus}
-	case '-':
-		l.next()
-		return Token{Kind: Minus}
-	case '*':
-		l.next()
-		return Token{Kind: Asterisk}
-	case '/':
-		l.next()
-		return Token{Kind: Slash}
-	case '%':
-		l.next()
-		return Token{Kind: Percent}
-	case '^':
-		l.next()
-		return Token{Kind: Caret}
 	case '(':
 		l.next()
 		return Token{Kind: OpenParen}
 	case ')':
 		l.next()
 		return Token{Kind: CloseParen}
+	case '[':
+		l.next()
+		return Token{Kind: OpenBracket}
+	case ']':
+		l.next()
+		return Token{Kind: CloseBracket}
 	case ',':
 		l.next()
 		return Token{Kind: Comma}
+	case '?':
+		l.next()
+		return Token{Kind: Question}
+	case ':':
+		l.next()
+		return Token{Kind: Colon}
+	case '=':
+		l.next()
+		return Token{Kind: Assign}
+	case ';':
+		l.next()
+		return Token{Kind: Semicolon}
 	}
 
-	if unicode.IsDigit(l.current()) {
-		var num []rune
+	unexpected := l.current()
+	l.next()
 
-		haveDecimalPoint := false
-		for unicode.IsDigit(l.current()) || (!haveDecimalPoint && l.current() == '.') {
-			num = append(num, l.current())
-			haveDecimalPoint = l.current() == '.'
+	return Token{
+		Kind:  Unexpected,
+		Value: string(unexpected),
+	}
+}
 
-			if !l.next() { // EOF
-				break
-			}
+// skip consumes n bytes of input.
+func (l *Lexer) skip(n int) {
+	for end := l.pos + n; l.pos < end; {
+		l.next()
+	}
+}
+
+// scanIdent scans identifiers: a letter or underscore followed by letters,
+// digits and underscores (x2, log10, θ, Δt). Identifiers joined with dots
+// form a single namespaced name (stats.mean, phys.c).
+func (l *Lexer) scanIdent() Token {
+	start := l.pos
+
+	for {
+		for !l.eof() && isIdentPart(l.current()) {
+			l.next()
+		}
+
+		if l.at(0) != '.' || l.pos+1 >= len(l.source) {
+			break
 		}
 
-		return Token{
-			Kind:  Number,
-			Value: string(num),
+		if r, _ := utf8.DecodeRuneInString(l.source[l.pos+1:]); !isIdentStart(r) {
+			break
 		}
+
+		l.next() // .
 	}
 
-	if unicode.IsLetter(l.current()) || l.current() == '_' {
-		var ident []rune
+	return Token{
+		Kind:  Ident,
+		Value: l.source[start:l.pos],
+	}
+}
 
-		for unicode.IsLetter(l.current()) || l.current() == '_' {
-			ident = append(ident, l.current())
+func isIdentStart(r rune) bool {
+	return unicode.IsLetter(r) || r == '_'
+}
+
+func isIdentPart(r rune) bool {
+	return isIdentStart(r) || unicode.IsDigit(r)
+}
 
-			if !l.next() { // EOF
-				break
+// scanNumber scans decimal literals with an optional fraction and exponent
+// (1, 2.5, .5, 1e-9, 6.02E23) and integer literals with a base prefix
+// (0xFF, 0o17, 0b1010). Digits may be separated by underscores (1_000_000),
+// the parser rejects misplaced ones. A decimal literal directly followed
+// by i is imaginary (2i, 0.5i), unless the i starts an identifier (2in).
+func (l *Lexer) scanNumber() Token {
+	start := l.pos
+
+	if l.at(0) == '0' && isBasePrefix(l.at(1)) {
+		isBaseDigit := isDigit
+		switch l.at(1) {
+		case 'x', 'X':
+			isBaseDigit = isHexDigit
+		case 'o', 'O':
+			isBaseDigit = isOctalDigit
+		case 'b', 'B':
+			isBaseDigit = isBinaryDigit
+		}
+
+		l.next()
+		l.next()
+		l.skipDigits(isBaseDigit)
+	} else {
+		l.skipDigits(isDigit)
+		if l.at(0) == '.' && l.at(1) != '.' { // 1..5 may be a range
+			l.next()
+			l.skipDigits(isDigit)
+		}
+
+		// exponent, unless the 'e' starts an identifier: 2e, 2exp(1)
+		if e := l.at(0); e == 'e' || e == 'E' {
+			digit := 1
+			if sign := l.at(1); sign == '+' || sign == '-' {
+				digit = 2
+			}
+
+			if isDigit(l.at(digit)) {
+				for i := 0; i < digit; i++ {
+					l.next()
+				}
+				l.skipDigits(isDigit)
 			}
 		}
 
-		return Token{
-			Kind:  Ident,
-			Value: string(ident),
+		if l.at(0) == 'i' && !l.identPartAt(1) {
+			l.next()
+			return Token{
+				Kind:  Imaginary,
+				Value: l.source[start:l.pos],
+			}
 		}
 	}
 
 	return Token{
-		Kind:  Unexpected,
-		Value: string(l.current()),
+		Kind:  Number,
+		Value: l.source[start:l.pos],
+	}
+}
+
+// identPartAt reports whether the rune starting i bytes ahead may continue an identifier.
+func (l *Lexer) identPartAt(i int) bool {
+	if l.pos+i >= len(l.source) {
+		return false
+	}
+
+	r, _ := utf8.DecodeRuneInString(l.source[l.pos+i:])
+	return isIdentPart(r)
+}
+
+func (l *Lexer) skipDigits(isBaseDigit func(c byte) bool) {
+	for isBaseDigit(l.at(0)) || l.at(0) == '_' {
+		l.next()
+	}
+}
+
+// at returns the byte i positions after the current one, or 0 past the end of input.
+func (l *Lexer) at(i int) byte {
+	if l.pos+i >= len(l.source) {
+		return 0
+	}
+
+	return l.source[l.pos+i]
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func isOctalDigit(c byte) bool { return '0' <= c && c <= '7' }
+
+func isBinaryDigit(c byte) bool { return c == '0' || c == '1' }
+
+func isBasePrefix(c byte) bool {
+	switch c {
+	case 'x', 'X', 'o', 'O', 'b', 'B':
+		return true
+	default:
+		return false
 	}
 }
 
 func (l *Lexer) Eat(expect Kind) bool {
-	prevPos := l.pos
-	prevEnd := l.end
+	saved := *l
 
 	tok := l.Next()
 	if tok.Kind != expect {
-		l.pos = prevPos
-		l.end = prevEnd
+		*l = saved
 		return false
 	}
 
 	return true
 }
 
+// Peek returns the n-th token after the next one without consuming anything,
+// so Peek(0) is the same as Ahead().
+func (l *Lexer) Peek(n int) Token {
+	saved := *l
+
+	tok := l.Next()
+	for i := 0; i < n; i++ {
+		tok = l.Next()
+	}
+	*l = saved
+
+	return tok
+}
+
 func (l *Lexer) Ahead() Token {
-	prevPos := l.pos
-	prevEnd := l.end
+	if l.aheadValid && l.aheadFrom == l.cursor {
+		return l.ahead
+	}
 
+	from := l.cursor
 	tok := l.Next()
-	l.pos = prevPos
-	l.end = prevEnd
+
+	l.ahead, l.aheadFrom, l.aheadTo, l.aheadValid = tok, from, l.cursor, true
+	l.cursor = from
 
 	return tok
 }
@@ -6,6 +6,8 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "..", "+", "-", "*", "/", "%", "^", "<", ">", "!", "±"}
+
 func TestLexer(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -16,40 +18,221 @@ func TestLexer(t *testing.T) {
 			name:  "simple",
 			input: "-(2.53+3)",
 			expected: []Token{
-				{Minus, ""},
-				{OpenParen, ""},
-				{Number, "2.53"},
-				{Plus, ""},
-				{Number, "3"},
-				{CloseParen, ""},
+				{Kind: Operator, Value: "-"},
+				{Kind: OpenParen},
+				{Kind: Number, Value: "2.53"},
+				{Kind: Operator, Value: "+"},
+				{Kind: Number, Value: "3"},
+				{Kind: CloseParen},
 			},
 		},
 		{
 			name:  "simple2",
 			input: "(x)",
 			expected: []Token{
-				{OpenParen, ""},
-				{Ident, "x"},
-				{CloseParen, ""},
+				{Kind: OpenParen},
+				{Kind: Ident, Value: "x"},
+				{Kind: CloseParen},
 			},
 		},
 		{
 			name:  "medium",
 			input: " sin(5) + foo(Pi, 3)^4 ",
 			expected: []Token{
-				{Ident, "sin"},
-				{OpenParen, ""},
-				{Number, "5"},
-				{CloseParen, ""},
-				{Plus, ""},
-				{Ident, "foo"},
-				{OpenParen, ""},
-				{Ident, "Pi"},
-				{Comma, ""},
-				{Number, "3"},
-				{CloseParen, ""},
-				{Caret, ""},
-				{Number, "4"},
+				{Kind: Ident, Value: "sin"},
+				{Kind: OpenParen},
+				{Kind: Number, Value: "5"},
+				{Kind: CloseParen},
+				{Kind: Operator, Value: "+"},
+				{Kind: Ident, Value: "foo"},
+				{Kind: OpenParen},
+				{Kind: Ident, Value: "Pi"},
+				{Kind: Comma},
+				{Kind: Number, Value: "3"},
+				{Kind: CloseParen},
+				{Kind: Operator, Value: "^"},
+				{Kind: Number, Value: "4"},
+			},
+		},
+		{
+			name:  "numbers",
+			input: "1e-9 6.02E23 .5 2. 0xFF 0o17 0b1010 1_000_000",
+			expected: []Token{
+				{Kind: Number, Value: "1e-9"},
+				{Kind: Number, Value: "6.02E23"},
+				{Kind: Number, Value: ".5"},
+				{Kind: Number, Value: "2."},
+				{Kind: Number, Value: "0xFF"},
+				{Kind: Number, Value: "0o17"},
+				{Kind: Number, Value: "0b1010"},
+				{Kind: Number, Value: "1_000_000"},
+			},
+		},
+		{
+			name:  "number-then-ident",
+			input: "2e 3E+x 0b12",
+			expected: []Token{
+				{Kind: Number, Value: "2"},
+				{Kind: Ident, Value: "e"},
+				{Kind: Number, Value: "3"},
+				{Kind: Ident, Value: "E"},
+				{Kind: Operator, Value: "+"},
+				{Kind: Ident, Value: "x"},
+				{Kind: Number, Value: "0b1"},
+				{Kind: Number, Value: "2"},
+			},
+		},
+		{
+			name:  "imaginary",
+			input: "3i+0.5i 1e2i 2in 0x1i",
+			expected: []Token{
+				{Kind: Imaginary, Value: "3i"},
+				{Kind: Operator, Value: "+"},
+				{Kind: Imaginary, Value: "0.5i"},
+				{Kind: Imaginary, Value: "1e2i"},
+				{Kind: Number, Value: "2"},
+				{Kind: Ident, Value: "in"},
+				{Kind: Number, Value: "0x1"},
+				{Kind: Ident, Value: "i"},
+			},
+		},
+		{
+			name:  "idents",
+			input: "x2 log10(_a_1) π*Δt θ",
+			expected: []Token{
+				{Kind: Ident, Value: "x2"},
+				{Kind: Ident, Value: "log10"},
+				{Kind: OpenParen},
+				{Kind: Ident, Value: "_a_1"},
+				{Kind: CloseParen},
+				{Kind: Ident, Value: "π"},
+				{Kind: Operator, Value: "*"},
+				{Kind: Ident, Value: "Δt"},
+				{Kind: Ident, Value: "θ"},
+			},
+		},
+		{
+			name:  "namespaced-idents",
+			input: "stats.mean(phys.c) + a.b.c2 x.5 y.",
+			expected: []Token{
+				{Kind: Ident, Value: "stats.mean"},
+				{Kind: OpenParen},
+				{Kind: Ident, Value: "phys.c"},
+				{Kind: CloseParen},
+				{Kind: Operator, Value: "+"},
+				{Kind: Ident, Value: "a.b.c2"},
+				{Kind: Ident, Value: "x"},
+				{Kind: Number, Value: ".5"},
+				{Kind: Ident, Value: "y"},
+				{Kind: Unexpected, Value: "."},
+			},
+		},
+		{
+			name:  "logic",
+			input: "a==b != c<d<=e>f>=g && !h || i xor j !x",
+			expected: []Token{
+				{Kind: Ident, Value: "a"},
+				{Kind: Operator, Value: "=="},
+				{Kind: Ident, Value: "b"},
+				{Kind: Operator, Value: "!="},
+				{Kind: Ident, Value: "c"},
+				{Kind: Operator, Value: "<"},
+				{Kind: Ident, Value: "d"},
+				{Kind: Operator, Value: "<="},
+				{Kind: Ident, Value: "e"},
+				{Kind: Operator, Value: ">"},
+				{Kind: Ident, Value: "f"},
+				{Kind: Operator, Value: ">="},
+				{Kind: Ident, Value: "g"},
+				{Kind: Operator, Value: "&&"},
+				{Kind: Operator, Value: "!"},
+				{Kind: Ident, Value: "h"},
+				{Kind: Operator, Value: "||"},
+				{Kind: Ident, Value: "i"},
+				{Kind: Ident, Value: "xor"},
+				{Kind: Ident, Value: "j"},
+				{Kind: Operator, Value: "!"},
+				{Kind: Ident, Value: "x"},
+			},
+		},
+		{
+			name:  "conditional",
+			input: "x?1:0",
+			expected: []Token{
+				{Kind: Ident, Value: "x"},
+				{Kind: Question},
+				{Kind: Number, Value: "1"},
+				{Kind: Colon},
+				{Kind: Number, Value: "0"},
+			},
+		},
+		{
+			name:  "lone-symbols",
+			input: "= & |",
+			expected: []Token{
+				{Kind: Assign},
+				{Kind: Unexpected, Value: "&"},
+				{Kind: Unexpected, Value: "|"},
+			},
+		},
+		{
+			name:  "statements",
+			input: "x = 1; f(a)=a==2",
+			expected: []Token{
+				{Kind: Ident, Value: "x"},
+				{Kind: Assign},
+				{Kind: Number, Value: "1"},
+				{Kind: Semicolon},
+				{Kind: Ident, Value: "f"},
+				{Kind: OpenParen},
+				{Kind: Ident, Value: "a"},
+				{Kind: CloseParen},
+				{Kind: Assign},
+				{Kind: Ident, Value: "a"},
+				{Kind: Operator, Value: "=="},
+				{Kind: Number, Value: "2"},
+			},
+		},
+		{
+			name:  "lambda",
+			input: "(a, b) -> a-b",
+			expected: []Token{
+				{Kind: OpenParen},
+				{Kind: Ident, Value: "a"},
+				{Kind: Comma},
+				{Kind: Ident, Value: "b"},
+				{Kind: CloseParen},
+				{Kind: Arrow},
+				{Kind: Ident, Value: "a"},
+				{Kind: Operator, Value: "-"},
+				{Kind: Ident, Value: "b"},
+			},
+		},
+		{
+			name:  "vectors",
+			input: "[1, x][0]",
+			expected: []Token{
+				{Kind: OpenBracket},
+				{Kind: Number, Value: "1"},
+				{Kind: Comma},
+				{Kind: Ident, Value: "x"},
+				{Kind: CloseBracket},
+				{Kind: OpenBracket},
+				{Kind: Number, Value: "0"},
+				{Kind: CloseBracket},
+			},
+		},
+		{
+			name:  "custom-operators",
+			input: "1..5 ±x <=>",
+			expected: []Token{
+				{Kind: Number, Value: "1"},
+				{Kind: Operator, Value: ".."},
+				{Kind: Number, Value: "5"},
+				{Kind: Operator, Value: "±"},
+				{Kind: Ident, Value: "x"},
+				{Kind: Operator, Value: "<="},
+				{Kind: Operator, Value: ">"},
 			},
 		},
 		{
@@ -60,13 +243,33 @@ func TestLexer(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		l := New(test.input)
+		l := New(test.input, operators...)
 
 		var tokens []Token
 		for tok := l.Next(); tok.Kind != EOF; tok = l.Next() {
+			tok.Span = Span{}
 			tokens = append(tokens, tok)
 		}
 
 		assert.Equal(t, test.expected, tokens)
 	}
 }
+
+func TestLexerSpans(t *testing.T) {
+	l := New("sin(x)\n  + π 2", operators...)
+
+	expected := []Span{
+		{Start: Position{0, 1, 1}, End: Position{3, 1, 4}},   // sin
+		{Start: Position{3, 1, 4}, End: Position{4, 1, 5}},   // (
+		{Start: Position{4, 1, 5}, End: Position{5, 1, 6}},   // x
+		{Start: Position{5, 1, 6}, End: Position{6, 1, 7}},   // )
+		{Start: Position{9, 2, 3}, End: Position{10, 2, 4}},  // +
+		{Start: Position{11, 2, 5}, End: Position{13, 2, 6}}, // π
+		{Start: Position{14, 2, 7}, End: Position{15, 2, 8}}, // 2
+		{Start: Position{15, 2, 8}, End: Position{15, 2, 8}}, // EOF
+	}
+
+	for _, span := range expected {
+		assert.Equal(t, span, l.Next().Span)
+	}
+}
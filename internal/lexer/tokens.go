@@ -1,8 +1,22 @@
 package lexer
 
+// Position is a location in the lexer input.
+type Position struct {
+	Offset int // byte offset, starting at 0
+	Line   int // line number, starting at 1
+	Column int // column number in runes, starting at 1
+}
+
+// Span is a half-open range [Start, End) of the lexer input.
+type Span struct {
+	Start Position
+	End   Position
+}
+
 type Token struct {
 	Kind  Kind
 	Value string
+	Span  Span
 }
 
 func (tok Token) String() string {
@@ -22,45 +36,54 @@ func (k Kind) String() string {
 		return "EOF"
 	case Unexpected:
 		return "Unexpected"
-	case Plus:
-		return "+"
-	case Minus:
-		return "-"
-	case Asterisk:
-		return "*"
-	case Slash:
-		return "/"
-	case Percent:
-		return "%"
-	case Caret:
-		return "^"
 	case OpenParen:
 		return "("
 	case CloseParen:
 		return ")"
+	case OpenBracket:
+		return "["
+	case CloseBracket:
+		return "]"
 	case Comma:
 		return ","
+	case Question:
+		return "?"
+	case Colon:
+		return ":"
+	case Assign:
+		return "="
+	case Semicolon:
+		return ";"
+	case Arrow:
+		return "->"
 	case Ident:
 		return "Ident"
 	case Number:
 		return "Number"
+	case Imaginary:
+		return "Imaginary"
+	case Operator:
+		return "Operator"
 	default:
 		return "Unknown kind: " + string(k)
 	}
 }
 
 const (
-	EOF        Kind = iota
-	Unexpected      //
-	Plus            // +
-	Minus           // -
-	Asterisk        // *
-	Slash           // /
-	Percent         // %
-	Caret           // ^
-	OpenParen       // (
-	CloseParen      // )
-	Comma           // ,
-	Ident           // foo
-	Number          // 123
+	EOF          Kind = iota
+	Unexpected        //
+	OpenParen         // (
+	CloseParen        // )
+	Comma             // ,
+	Question          // ?
+	Colon             // :
+	Assign            // =
+	Semicolon         // ;
+	Arrow             // ->
+	OpenBracket       // [
+	CloseBracket      // ]
+	Ident             // foo
+	Number            // 123
+	Imaginary         // 2i
+	Operator          // + <= ±, Value holds the symbol
 )
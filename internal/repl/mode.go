@@ -0,0 +1,67 @@
+package repl
+
+import "github.com/xjem/calculon"
+
+// modeSwitch forwards to the context of the current evaluation mode,
+// so that the mode can change under the global scope without losing its definitions.
+type modeSwitch struct {
+	std calculon.EvalContext
+	ctx calculon.EvalContext
+}
+
+func (m *modeSwitch) LookupVar(name string) (float64, bool) {
+	return m.ctx.LookupVar(name)
+}
+
+func (m *modeSwitch) LookupFunc(name string) (calculon.Function, bool) {
+	return m.ctx.LookupFunc(name)
+}
+
+func (m *modeSwitch) LookupValue(name string) (calculon.Value, bool) {
+	if valctx, ok := m.ctx.(calculon.ValueContext); ok {
+		return valctx.LookupValue(name)
+	}
+
+	f, found := m.ctx.LookupVar(name)
+	return calculon.Float(f), found
+}
+
+func (m *modeSwitch) LookupValueFunc(name string) (calculon.LazyValueFunction, bool) {
+	if valctx, ok := m.ctx.(calculon.ValueContext); ok {
+		return valctx.LookupValueFunc(name)
+	}
+
+	return nil, false
+}
+
+func (m *modeSwitch) LookupLazyFunc(name string) (calculon.LazyFunction, bool) {
+	if lazyctx, ok := m.ctx.(calculon.LazyContext); ok {
+		return lazyctx.LookupLazyFunc(name)
+	}
+
+	return nil, false
+}
+
+func (m *modeSwitch) LookupNamespace(name string) (calculon.EvalContext, bool) {
+	if nsctx, ok := m.ctx.(calculon.NamespaceContext); ok {
+		return nsctx.LookupNamespace(name)
+	}
+
+	return nil, false
+}
+
+func (m *modeSwitch) LookupArity(name string) (calculon.Arity, bool) {
+	if arityctx, ok := m.ctx.(calculon.ArityContext); ok {
+		return arityctx.LookupArity(name)
+	}
+
+	return calculon.Arity{}, false
+}
+
+func (m *modeSwitch) NumberValue(literal string, value float64) (calculon.Value, error) {
+	if numctx, ok := m.ctx.(calculon.NumberContext); ok {
+		return numctx.NumberValue(literal, value)
+	}
+
+	return calculon.Float(value), nil
+}
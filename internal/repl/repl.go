@@ -8,75 +8,167 @@ import (
 )
 
 type Repl struct {
-	globalScope *Scope
+	mode        *modeSwitch
+	globalScope *calculon.Scope
+	// names assigned in the global scope, see SetPrecision
+	vars map[string]bool
+	// functions defined in the global scope, see Derive
+	defs map[string]calculon.FunctionDef
 }
 
 func New(std calculon.EvalContext) *Repl {
+	mode := &modeSwitch{std: std, ctx: std}
 	return &Repl{
-		globalScope: NewScope(std),
+		mode:        mode,
+		globalScope: calculon.NewScope(mode),
+		vars:        map[string]bool{},
+		defs:        map[string]calculon.FunctionDef{},
 	}
 }
 
-func (r *Repl) Eval(input string) (float64, error) {
-	expr, err := calculon.Parse(input)
+// MaxPrecision bounds the precision of SetPrecision, the constants of
+// calculon.PrecisionMode take long to compute beyond it.
+const MaxPrecision = 1 << 16
+
+// SetPrecision makes the REPL compute with big floats of prec bits,
+// see calculon.PrecisionMode. Zero goes back to float64 numbers.
+// Definitions made so far are kept, going back to float64 numbers
+// turns the big floats assigned to variables into float64 ones.
+// Precisions above MaxPrecision are rejected.
+func (r *Repl) SetPrecision(prec uint) error {
+	if prec > MaxPrecision {
+		return fmt.Errorf("precision above %d bits", MaxPrecision)
+	}
+
+	if prec == 0 {
+		r.mode.ctx = r.mode.std
+		for name := range r.vars {
+			if val, found := r.globalScope.LookupValue(name); found {
+				if f, ok := val.(calculon.BigFloat); ok {
+					x, _ := f.Float64()
+					r.globalScope.SetVar(name, x)
+				}
+			}
+		}
+
+		return nil
+	}
+
+	r.mode.ctx = calculon.PrecisionMode(r.mode.std, prec)
+	return nil
+}
+
+// Eval runs the input as a program in the global scope, so its definitions
+// persist between calls. It reports false if the last statement was an
+// assignment or a definition, which have no result worth printing.
+func (r *Repl) Eval(input string) (calculon.Value, bool, error) {
+	prog, err := calculon.ParseProgram(input)
 	if err != nil {
-		return 0, fmt.Errorf("parse: %w", err)
+		return nil, false, fmt.Errorf("parse: %w", err)
 	}
 
-	return expr.Eval(r.globalScope)
+	// statements run one by one, to keep track of the names they define
+	var result calculon.Value = calculon.Float(0)
+	for _, stmt := range prog.Statements {
+		if result, err = stmt.EvalValue(r.globalScope); err != nil {
+			return nil, false, err
+		}
+
+		r.define(stmt)
+	}
+
+	if len(prog.Statements) == 0 {
+		return nil, false, nil
+	}
+
+	switch prog.Statements[len(prog.Statements)-1].(type) {
+	case calculon.Assignment, calculon.FunctionDef:
+		return result, false, nil
+	}
+
+	return result, true, nil
 }
 
-func (r *Repl) Define(input string) error {
-	vars := strings.Split(input, "=")
-	if len(vars) != 2 {
-		return fmt.Errorf("multiple assignment")
+// define keeps track of the names a statement run in the global scope defines.
+func (r *Repl) define(stmt calculon.Expression) {
+	switch stmt := stmt.(type) {
+	case calculon.FunctionDef:
+		r.defs[stmt.Name] = stmt
+	case calculon.Assignment:
+		delete(r.defs, stmt.Name)
+		switch value := unparen(stmt.Value).(type) {
+		case calculon.Lambda:
+			r.defs[stmt.Name] = calculon.FunctionDef{Name: stmt.Name, Params: value.Params, Body: value.Body}
+		case calculon.Variable:
+			// g = f
+			if def, found := r.defs[value.Name]; found {
+				r.defs[stmt.Name] = def
+			}
+		}
+
+		r.vars[stmt.Name] = true
 	}
+}
 
-	definition, err := calculon.Parse(strings.TrimSpace(vars[0]))
-	if err != nil {
-		return err
+// Derive differentiates an expression by the variable written after it,
+// as in sin(2*x) x, see calculon.Derive. Calls of the functions defined
+// in the REPL are differentiated through their bodies.
+func (r *Repl) Derive(input string) (calculon.Expression, error) {
+	split := strings.LastIndexAny(input, " \t")
+	if split < 0 {
+		return nil, fmt.Errorf("usage: :d EXPR VAR")
 	}
 
-	body, err := calculon.Parse(strings.TrimSpace(vars[1]))
+	expr, err := calculon.Parse(input[:split])
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("parse: %w", err)
 	}
 
-	switch definition := definition.(type) {
-	case calculon.Variable:
-		num, ok := body.(calculon.Number)
+	return calculon.Derive(r.inline(expr, map[string]bool{}), strings.TrimSpace(input[split:]))
+}
+
+// inline replaces the calls of functions defined in the REPL with their bodies,
+// the parameters replaced with the arguments. Recursive calls are left as they are.
+func (r *Repl) inline(expr calculon.Expression, inlining map[string]bool) calculon.Expression {
+	return calculon.Rewrite(expr, func(e calculon.Expression) calculon.Expression {
+		call, ok := e.(calculon.FunctionCall)
 		if !ok {
-			return fmt.Errorf("invalid variable type: %T", body)
+			return e
 		}
 
-		r.globalScope.SetVar(definition.Name, num.Value)
-
-	case calculon.FunctionCall:
-		var requiredArgs []string
-		for _, arg := range definition.Args {
-			vararg, ok := arg.(calculon.Variable)
-			if !ok {
-				return fmt.Errorf("unsupported function argument: %T", arg)
-			}
-
-			requiredArgs = append(requiredArgs, vararg.Name)
+		def, found := r.defs[call.Name]
+		if !found || inlining[call.Name] || len(call.Args) != len(def.Params) {
+			return e
 		}
 
-		fnScope := NewScope(r.globalScope)
-		r.globalScope.SetFunc(definition.Name, func(args []float64) (float64, error) {
-			if len(args) != len(requiredArgs) {
-				return 0, fmt.Errorf("%s: bad params count (want %d, got %d)", definition.String(), len(requiredArgs), len(args))
-			}
+		args := make(map[string]calculon.Expression, len(def.Params))
+		for i, param := range def.Params {
+			args[param] = call.Args[i]
+		}
 
-			for i, paramName := range requiredArgs {
-				fnScope.vars[paramName] = args[i]
+		body := calculon.Rewrite(def.Body, func(e calculon.Expression) calculon.Expression {
+			if v, ok := e.(calculon.Variable); ok {
+				if arg, found := args[v.Name]; found {
+					return arg
+				}
 			}
 
-			return body.Eval(fnScope)
+			return e
 		})
-	default:
-		return fmt.Errorf("invalid definition type: %T", definition)
-	}
 
-	return nil
+		inlining[call.Name] = true
+		defer delete(inlining, call.Name)
+		return r.inline(body, inlining)
+	})
+}
+
+func unparen(expr calculon.Expression) calculon.Expression {
+	for {
+		paren, ok := expr.(calculon.Parentheses)
+		if !ok {
+			return expr
+		}
+
+		expr = paren.Expr
+	}
 }
@@ -0,0 +1,77 @@
+package repl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xjem/calculon"
+)
+
+func TestReplPrecision(t *testing.T) {
+	r := New(calculon.MathContext())
+
+	assert.NoError(t, r.SetPrecision(100))
+	_, ok, err := r.Eval("third = 1/3; v = [third]")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	result, ok, err := r.Eval("third")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "0.333333333333333333333333333333", result.String())
+
+	// back to float64, variables hold float64 numbers again
+	assert.NoError(t, r.SetPrecision(0))
+	result, _, err = r.Eval("third")
+	assert.NoError(t, err)
+	assert.Equal(t, calculon.Float(1.0/3), result)
+
+	result, _, err = r.Eval("third * 3 + v[0]")
+	assert.NoError(t, err)
+	assert.Equal(t, calculon.Float(1+1.0/3), result)
+
+	// and to big floats once more
+	assert.NoError(t, r.SetPrecision(100))
+	result, _, err = r.Eval("third * 3")
+	assert.NoError(t, err)
+	assert.Equal(t, calculon.KindBigFloat, result.Kind())
+
+	// precisions too large to compute with are rejected, the mode stays
+	assert.EqualError(t, r.SetPrecision(4000000000), "precision above 65536 bits")
+	result, _, err = r.Eval("1/3")
+	assert.NoError(t, err)
+	assert.Equal(t, "0.333333333333333333333333333333", result.String())
+}
+
+func TestReplDerive(t *testing.T) {
+	r := New(calculon.MathContext())
+	_, _, err := r.Eval("f(x) = x^2; g = t -> sin(t) * k; h = f; fact(n) = n <= 1 ? 1 : n * fact(n - 1)")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		input    string
+		expected string
+		err      string
+	}{
+		{input: "sin(2*x) x", expected: "cos(2 * x) * 2"},
+		{input: "f(x) x", expected: "2 * x"},
+		{input: "f(3 * x) + h(y) x", expected: "2 * (3 * x) * 3"},
+		{input: "f(1 + x) x", expected: "2 * (1 + x)"},
+		{input: "g(f(x)) x", expected: "cos(x^2) * (2 * x) * k"},
+		{input: "g(x) k", expected: "sin(x)"},
+		{input: "fact(x) x", err: "cannot differentiate fact(x - 1): no derivative of fact()"},
+		{input: "x^2", err: "usage: :d EXPR VAR"},
+	}
+
+	for _, test := range tests {
+		derivative, err := r.Derive(test.input)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.input)
+			continue
+		}
+
+		if assert.NoError(t, err, test.input) {
+			assert.Equal(t, test.expected, derivative.String(), test.input)
+		}
+	}
+}
@@ -0,0 +1,467 @@
+package calculon
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+const KindInterval Kind = "interval"
+
+// Interval is the set of real numbers from Lo to Hi, see IntervalMode.
+// Operators on intervals give intervals holding every result of the operator
+// for numbers taken from the operands. A degenerate interval, whose bounds
+// are equal, can be used as a number.
+type Interval struct {
+	Lo, Hi float64
+}
+
+func (iv Interval) Kind() Kind { return KindInterval }
+
+func (iv Interval) String() string {
+	return "[" + formatFloat(iv.Lo) + ", " + formatFloat(iv.Hi) + "]"
+}
+
+func (iv Interval) float() (float64, bool) {
+	return iv.Lo, iv.Lo == iv.Hi
+}
+
+// Contains reports whether x lies in the interval.
+func (iv Interval) Contains(x float64) bool {
+	return iv.Lo <= x && x <= iv.Hi
+}
+
+var entire = Interval{math.Inf(-1), math.Inf(1)}
+
+func nextDown(x float64) float64 { return math.Nextafter(x, math.Inf(-1)) }
+
+func nextUp(x float64) float64 { return math.Nextafter(x, math.Inf(1)) }
+
+// The bounds of results are rounded outwards: down for lower bounds, up for upper ones.
+// A rounded result is moved by one ulp when the error of the operation, computed
+// exactly with the TwoSum algorithm or a fused multiply-add, shows it is on the wrong side.
+
+func addDown(a, b float64) float64 {
+	s := a + b
+	if err := twoSumErr(a, b, s); err < 0 {
+		return nextDown(s)
+	}
+
+	return s
+}
+
+func addUp(a, b float64) float64 {
+	s := a + b
+	if err := twoSumErr(a, b, s); err > 0 {
+		return nextUp(s)
+	}
+
+	return s
+}
+
+// twoSumErr returns a + b - s exactly, for s = a + b rounded.
+func twoSumErr(a, b, s float64) float64 {
+	if math.IsInf(s, 0) && !math.IsInf(a, 0) && !math.IsInf(b, 0) {
+		// overflow, a + b is finite
+		return -s
+	}
+
+	if math.IsInf(s, 0) || math.IsNaN(s) {
+		return 0
+	}
+
+	bb := s - a
+	return (a - (s - bb)) + (b - bb)
+}
+
+func mulDown(a, b float64) float64 {
+	p := mulZero(a, b)
+	if err := math.FMA(a, b, -p); err < 0 {
+		return nextDown(p)
+	}
+
+	return p
+}
+
+func mulUp(a, b float64) float64 {
+	p := mulZero(a, b)
+	if err := math.FMA(a, b, -p); err > 0 {
+		return nextUp(p)
+	}
+
+	return p
+}
+
+// mulZero multiplies with 0 * Inf = 0, which is right for the bounds of intervals.
+func mulZero(a, b float64) float64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+
+	return a * b
+}
+
+func divDown(a, b float64) float64 {
+	q := a / b
+	if divErr(a, b, q) < 0 {
+		return nextDown(q)
+	}
+
+	return q
+}
+
+func divUp(a, b float64) float64 {
+	q := a / b
+	if divErr(a, b, q) > 0 {
+		return nextUp(q)
+	}
+
+	return q
+}
+
+// divErr returns a number of the sign of a/b - q.
+func divErr(a, b, q float64) float64 {
+	switch {
+	case q == 0 && a != 0: // underflow
+		return math.Copysign(1, a) * math.Copysign(1, b)
+	case math.IsInf(q, 0) && !math.IsInf(a, 0) && b != 0: // overflow
+		return -q
+	case math.IsInf(q, 0) || math.IsInf(a, 0) || math.IsInf(b, 0):
+		return 0
+	default:
+		return math.FMA(-q, b, a) * math.Copysign(1, b)
+	}
+}
+
+// widen moves both bounds one ulp outwards, for functions that are not correctly rounded.
+func widen(iv Interval) Interval {
+	return Interval{nextDown(iv.Lo), nextUp(iv.Hi)}
+}
+
+func ivAdd(l, r Interval) Interval {
+	return Interval{addDown(l.Lo, r.Lo), addUp(l.Hi, r.Hi)}
+}
+
+func ivSub(l, r Interval) Interval {
+	return Interval{addDown(l.Lo, -r.Hi), addUp(l.Hi, -r.Lo)}
+}
+
+func ivMul(l, r Interval) Interval {
+	lo := math.Min(math.Min(mulDown(l.Lo, r.Lo), mulDown(l.Lo, r.Hi)), math.Min(mulDown(l.Hi, r.Lo), mulDown(l.Hi, r.Hi)))
+	hi := math.Max(math.Max(mulUp(l.Lo, r.Lo), mulUp(l.Lo, r.Hi)), math.Max(mulUp(l.Hi, r.Lo), mulUp(l.Hi, r.Hi)))
+	return Interval{lo, hi}
+}
+
+// ivDiv divides by intervals containing zero as well. The quotient is then
+// unbounded on one or both sides, and the result is the smallest interval
+// holding it: [1, 2] / [0, 1] is [1, Inf], [1, 2] / [-1, 1] is [-Inf, Inf].
+func ivDiv(l, r Interval) (Interval, error) {
+	switch {
+	case r.Lo == 0 && r.Hi == 0:
+		return Interval{}, fmt.Errorf("divide by zero")
+	case l.Lo == 0 && l.Hi == 0:
+		return Interval{0, 0}, nil
+	case r.Lo > 0 || r.Hi < 0:
+		lo := math.Min(math.Min(divDown(l.Lo, r.Lo), divDown(l.Lo, r.Hi)), math.Min(divDown(l.Hi, r.Lo), divDown(l.Hi, r.Hi)))
+		hi := math.Max(math.Max(divUp(l.Lo, r.Lo), divUp(l.Lo, r.Hi)), math.Max(divUp(l.Hi, r.Lo), divUp(l.Hi, r.Hi)))
+		return Interval{lo, hi}, nil
+	case r.Lo == 0 && l.Lo > 0: // [a, b] / [0, d]
+		return Interval{divDown(l.Lo, r.Hi), math.Inf(1)}, nil
+	case r.Lo == 0 && l.Hi < 0:
+		return Interval{math.Inf(-1), divUp(l.Hi, r.Hi)}, nil
+	case r.Hi == 0 && l.Lo > 0: // [a, b] / [c, 0]
+		return Interval{math.Inf(-1), divUp(l.Lo, r.Lo)}, nil
+	case r.Hi == 0 && l.Hi < 0:
+		return Interval{divDown(l.Hi, r.Lo), math.Inf(1)}, nil
+	default:
+		return entire, nil
+	}
+}
+
+// ivPow computes l^r. Integer powers are exact up to rounding, even ones
+// are never negative: [-2, 3]^2 is [0, 9]. Other powers require l >= 0.
+func ivPow(l, r Interval) (Interval, error) {
+	if n, ok := r.float(); ok && n == math.Trunc(n) && math.Abs(n) <= maxExactExponent {
+		if n < 0 {
+			p := ivIntPow(l, int(-n))
+			return ivDiv(Interval{1, 1}, p)
+		}
+
+		return ivIntPow(l, int(n)), nil
+	}
+
+	if l.Hi < 0 {
+		return Interval{}, fmt.Errorf("interval power of a negative base: %s^%s", l, r)
+	}
+
+	// numbers below zero have no real powers
+	l.Lo = math.Max(l.Lo, 0)
+
+	// x^y is monotonic in x and in y, so the bounds are among the corners
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, x := range []float64{l.Lo, l.Hi} {
+		for _, y := range []float64{r.Lo, r.Hi} {
+			p := math.Pow(x, y)
+			lo, hi = math.Min(lo, p), math.Max(hi, p)
+		}
+	}
+
+	if math.IsNaN(lo) || math.IsNaN(hi) {
+		return Interval{}, fmt.Errorf("interval power is not defined: %s^%s", l, r)
+	}
+
+	// math.Pow is accurate to about one ulp
+	iv := widen(widen(Interval{lo, hi}))
+	iv.Lo = math.Max(iv.Lo, 0)
+	return iv, nil
+}
+
+func ivIntPow(x Interval, n int) Interval {
+	switch {
+	case n == 0:
+		return Interval{1, 1}
+	case x.Lo >= 0:
+		return Interval{powDown(x.Lo, n), powUp(x.Hi, n)}
+	case n%2 == 1: // odd powers are increasing
+		return Interval{-powUp(-x.Lo, n), powUp(x.Hi, n)}
+	case x.Hi <= 0:
+		return Interval{powDown(-x.Hi, n), powUp(-x.Lo, n)}
+	default: // even powers of intervals around zero
+		return Interval{0, powUp(math.Max(-x.Lo, x.Hi), n)}
+	}
+}
+
+// powDown and powUp compute x^n for x >= 0 by squaring, rounding every step.
+func powDown(x float64, n int) float64 {
+	result := 1.0
+	for ; n > 0; n >>= 1 {
+		if n&1 == 1 {
+			result = mulDown(result, x)
+		}
+
+		x = mulDown(x, x)
+	}
+
+	return result
+}
+
+func powUp(x float64, n int) float64 {
+	result := 1.0
+	for ; n > 0; n >>= 1 {
+		if n&1 == 1 {
+			result = mulUp(result, x)
+		}
+
+		x = mulUp(x, x)
+	}
+
+	return result
+}
+
+// ivCompare gives 1 if a comparison holds for all numbers of the operands,
+// 0 if for none, and [0, 1] if it depends on which numbers are taken.
+func ivCompare(all, none bool) Interval {
+	switch {
+	case all:
+		return Interval{1, 1}
+	case none:
+		return Interval{0, 0}
+	default:
+		return Interval{0, 1}
+	}
+}
+
+var intervalOps = map[string]func(l, r Interval) (Interval, error){
+	"+": func(l, r Interval) (Interval, error) { return ivAdd(l, r), nil },
+	"-": func(l, r Interval) (Interval, error) { return ivSub(l, r), nil },
+	"*": func(l, r Interval) (Interval, error) { return ivMul(l, r), nil },
+	"/": ivDiv,
+	"^": ivPow,
+	"<": func(l, r Interval) (Interval, error) { return ivCompare(l.Hi < r.Lo, l.Lo >= r.Hi), nil },
+	"<=": func(l, r Interval) (Interval, error) {
+		return ivCompare(l.Hi <= r.Lo, l.Lo > r.Hi), nil
+	},
+	">": func(l, r Interval) (Interval, error) { return ivCompare(l.Lo > r.Hi, l.Hi <= r.Lo), nil },
+	">=": func(l, r Interval) (Interval, error) {
+		return ivCompare(l.Lo >= r.Hi, l.Hi < r.Lo), nil
+	},
+	"==": func(l, r Interval) (Interval, error) {
+		return ivCompare(l.Lo == l.Hi && l == r, l.Hi < r.Lo || r.Hi < l.Lo), nil
+	},
+	"!=": func(l, r Interval) (Interval, error) {
+		return ivCompare(l.Hi < r.Lo || r.Hi < l.Lo, l.Lo == l.Hi && l == r), nil
+	},
+}
+
+func init() {
+	for op, fn := range intervalOps {
+		fn := fn
+		eval := func(l, r Value) (Value, error) {
+			return fn(toInterval(l), toInterval(r))
+		}
+
+		infixValueOps[infixKey{op: op, left: KindInterval, right: KindInterval}] = eval
+		infixValueOps[infixKey{op: op, left: KindInterval, right: KindNumber}] = eval
+		infixValueOps[infixKey{op: op, left: KindNumber, right: KindInterval}] = eval
+	}
+
+	unaryValueOps[unaryKey{op: "-", kind: KindInterval}] = func(x Value) (Value, error) {
+		iv := x.(Interval)
+		return Interval{-iv.Hi, -iv.Lo}, nil
+	}
+	unaryValueOps[unaryKey{op: "+", kind: KindInterval}] = func(x Value) (Value, error) { return x, nil }
+}
+
+// toInterval converts an interval or a number.
+func toInterval(val Value) Interval {
+	if iv, ok := val.(Interval); ok {
+		return iv
+	}
+
+	f, _ := realValue(val)
+	return Interval{float64(f), float64(f)}
+}
+
+// literalInterval is the smallest interval holding the number a literal spells,
+// which may lie between two floats: 0.1 is [0.09999999999999999, 0.1].
+func literalInterval(literal string, value float64) Interval {
+	exact, ok := parseRational(literal, value)
+	if literal == "" || !ok || math.IsInf(value, 0) {
+		return Interval{value, value}
+	}
+
+	switch exact.Cmp(new(big.Rat).SetFloat64(value)) {
+	case -1:
+		return Interval{nextDown(value), value}
+	case 1:
+		return Interval{value, nextUp(value)}
+	default:
+		return Interval{value, value}
+	}
+}
+
+// intervalFunc makes a function of one interval, numbers are taken as degenerate intervals.
+func intervalFunc(name string, fn func(x Interval) (Interval, bool)) LazyValueFunction {
+	return eagerValue(func(args []Value) (Value, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s() requires 1 arg", name)
+		}
+
+		if _, ok := args[0].(Interval); !ok {
+			if _, ok := realValue(args[0]); !ok {
+				return nil, fmt.Errorf("%s(): not a number: %s", name, args[0])
+			}
+		}
+
+		x := toInterval(args[0])
+		result, ok := fn(x)
+		if !ok {
+			return nil, fmt.Errorf("%s(): not defined for %s", name, x)
+		}
+
+		return result, nil
+	})
+}
+
+// ivSinCos bounds sin x + phase over x, whose maxima lie at Pi/2 + 2kPi.
+func ivSinCos(x Interval, phase float64, fn func(float64) float64) (Interval, bool) {
+	if math.IsInf(x.Lo, 0) || math.IsInf(x.Hi, 0) || x.Hi-x.Lo >= 2*math.Pi {
+		return Interval{-1, 1}, true
+	}
+
+	a, b := fn(x.Lo), fn(x.Hi)
+	iv := widen(Interval{math.Min(a, b), math.Max(a, b)})
+
+	// extremes inside the interval; the tolerance makes up for the rounding of Pi
+	tol := 1e-15 * (1 + math.Max(math.Abs(x.Lo), math.Abs(x.Hi)))
+	peak := math.Pi/2 - phase
+	if k := math.Ceil((x.Lo - tol - peak) / (2 * math.Pi)); peak+2*k*math.Pi <= x.Hi+tol {
+		iv.Hi = 1
+	}
+
+	if k := math.Ceil((x.Lo - tol - peak - math.Pi) / (2 * math.Pi)); peak+math.Pi+2*k*math.Pi <= x.Hi+tol {
+		iv.Lo = -1
+	}
+
+	return Interval{math.Max(iv.Lo, -1), math.Min(iv.Hi, 1)}, true
+}
+
+// intervalModeFuncs replace the builtins of the same name in IntervalMode.
+var intervalModeFuncs = map[string]LazyValueFunction{
+	"sin": intervalFunc("sin", func(x Interval) (Interval, bool) {
+		return ivSinCos(x, 0, math.Sin)
+	}),
+	"cos": intervalFunc("cos", func(x Interval) (Interval, bool) {
+		return ivSinCos(x, math.Pi/2, math.Cos)
+	}),
+	"exp": intervalFunc("exp", func(x Interval) (Interval, bool) {
+		iv := widen(Interval{math.Exp(x.Lo), math.Exp(x.Hi)})
+		return Interval{math.Max(iv.Lo, 0), iv.Hi}, true
+	}),
+	"log": intervalFunc("log", func(x Interval) (Interval, bool) {
+		if x.Hi <= 0 {
+			return Interval{}, false
+		}
+
+		// numbers up to zero have no logarithm
+		return widen(Interval{math.Log(math.Max(x.Lo, 0)), math.Log(x.Hi)}), true
+	}),
+	"sqrt": intervalFunc("sqrt", func(x Interval) (Interval, bool) {
+		if x.Hi < 0 {
+			return Interval{}, false
+		}
+
+		iv := widen(Interval{math.Sqrt(math.Max(x.Lo, 0)), math.Sqrt(x.Hi)})
+		return Interval{math.Max(iv.Lo, 0), iv.Hi}, true
+	}),
+	"abs": intervalFunc("abs", func(x Interval) (Interval, bool) {
+		switch {
+		case x.Lo >= 0:
+			return x, true
+		case x.Hi <= 0:
+			return Interval{-x.Hi, -x.Lo}, true
+		default:
+			return Interval{0, math.Max(-x.Lo, x.Hi)}, true
+		}
+	}),
+}
+
+// IntervalMode evaluates the numbers of ctx as intervals, so that the result
+// bounds every value the expression takes for numbers from the interval variables:
+//
+//	ctx.SetValue("r", Interval{9.9, 10.1})
+//	EvalInterval(Parse("Pi * r^2"), ctx) // [307.9074959783356, 320.4738665926949]
+//
+// Bounds are rounded outwards, and literals that floats cannot hold, such as 0.1,
+// as well as Pi and E, become the narrowest intervals around them. Comparisons give
+// [0, 1] where the result depends on the numbers taken, such results cannot be used
+// as conditions. The functions sin, cos, exp, log, sqrt and abs take intervals,
+// functions computing with float64 numbers fail for intervals other than single numbers.
+func IntervalMode(ctx EvalContext) EvalContext {
+	return &modeContext{
+		parent: ctx,
+		number: func(literal string, value float64) (Value, error) {
+			return literalInterval(literal, value), nil
+		},
+		funcs: intervalModeFuncs,
+		consts: map[string]Value{
+			"Pi": Interval{math.Pi, nextUp(math.Pi)},
+			"E":  Interval{math.E, nextUp(math.E)},
+		},
+	}
+}
+
+// EvalInterval evaluates expr in IntervalMode(ctx).
+func EvalInterval(expr Expression, ctx EvalContext) (Interval, error) {
+	val, err := expr.EvalValue(IntervalMode(ctx))
+	if err != nil {
+		return Interval{}, err
+	}
+
+	switch val.(type) {
+	case Interval, Float:
+		return toInterval(val), nil
+	default:
+		return Interval{}, fmt.Errorf("%s used as an interval: %s", val.Kind(), val)
+	}
+}
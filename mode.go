@@ -0,0 +1,159 @@
+package calculon
+
+var (
+	_ NumberContext    = (*modeContext)(nil)
+	_ ValueContext     = (*modeContext)(nil)
+	_ NamespaceContext = (*modeContext)(nil)
+	_ LazyContext      = (*modeContext)(nil)
+	_ ArityContext     = (*modeContext)(nil)
+)
+
+// NumberContext is implemented by contexts that evaluate numbers as values
+// of another kind, such as complex numbers, see ComplexMode.
+type NumberContext interface {
+	EvalContext
+	// NumberValue converts a number literal, as spelled in the input,
+	// or the value of a numeric variable, in which case literal is empty.
+	NumberValue(literal string, value float64) (Value, error)
+}
+
+// numberValue converts a number the way ctx evaluates numbers.
+func numberValue(ctx EvalContext, literal string, value float64) (Value, error) {
+	if numctx, ok := ctx.(NumberContext); ok {
+		return numctx.NumberValue(literal, value)
+	}
+
+	return Float(value), nil
+}
+
+// modeContext evaluates the numbers of its parent as values of another kind,
+// and replaces the functions that have a different meaning for them.
+type modeContext struct {
+	parent EvalContext
+	number func(literal string, value float64) (Value, error)
+	funcs  map[string]LazyValueFunction
+	// replacements for the builtin variables, such as Pi, used
+	// when the parent has them with their builtin values
+	consts map[string]Value
+}
+
+func (m *modeContext) NumberValue(literal string, value float64) (Value, error) {
+	return m.number(literal, value)
+}
+
+func (m *modeContext) LookupVar(name string) (float64, bool) {
+	return m.parent.LookupVar(name)
+}
+
+func (m *modeContext) LookupValue(name string) (Value, bool) {
+	val, found := lookupValue(m.parent, name)
+	if c, ok := m.consts[name]; ok && found && val == Value(Float(builtinVars[name])) {
+		return c, true
+	}
+
+	return val, found
+}
+
+func (m *modeContext) LookupFunc(name string) (Function, bool) {
+	if _, found := m.funcs[name]; found {
+		return nil, false
+	}
+
+	return m.parent.LookupFunc(name)
+}
+
+func (m *modeContext) LookupLazyFunc(name string) (LazyFunction, bool) {
+	if _, found := m.funcs[name]; found {
+		return nil, false
+	}
+
+	if parent, ok := m.parent.(LazyContext); ok {
+		return parent.LookupLazyFunc(name)
+	}
+
+	return nil, false
+}
+
+func (m *modeContext) LookupValueFunc(name string) (LazyValueFunction, bool) {
+	if fn, found := m.funcs[name]; found {
+		return fn, true
+	}
+
+	if parent, ok := m.parent.(ValueContext); ok {
+		return parent.LookupValueFunc(name)
+	}
+
+	return nil, false
+}
+
+// LookupArity gives the arities of the parent, the functions of the mode
+// take the arguments of those they replace.
+func (m *modeContext) LookupArity(name string) (Arity, bool) {
+	return lookupArity(m.parent, name)
+}
+
+func (m *modeContext) LookupNamespace(name string) (EvalContext, bool) {
+	if parent, ok := m.parent.(NamespaceContext); ok {
+		return parent.LookupNamespace(name)
+	}
+
+	return nil, false
+}
+
+// modal is implemented by the contexts of evaluation modes. User functions are
+// evaluated in the mode of their caller, under which withParent puts the
+// context the function was defined in.
+type modal interface {
+	EvalContext
+	withParent(parent EvalContext) EvalContext
+}
+
+func (m *modeContext) withParent(parent EvalContext) EvalContext {
+	mode := *m
+	mode.parent = parent
+	return &mode
+}
+
+// parentOf returns the context ctx layers its names over, if it is one of those of this package.
+func parentOf(ctx EvalContext) (EvalContext, bool) {
+	switch ctx := ctx.(type) {
+	case *Scope:
+		return ctx.parent, true
+	case *modeContext:
+		return ctx.parent, true
+	case *dualContext:
+		return ctx.parent, true
+	}
+
+	return nil, false
+}
+
+// modeOf returns the innermost mode ctx evaluates in, looking through scopes.
+func modeOf(ctx EvalContext) (modal, bool) {
+	for ctx != nil {
+		if mode, ok := ctx.(modal); ok {
+			return mode, true
+		}
+
+		ctx, _ = parentOf(ctx)
+	}
+
+	return nil, false
+}
+
+// inMode returns ctx evaluated in the mode of caller, which it may be already,
+// as the frames of user functions defined during the evaluation are.
+func inMode(ctx, caller EvalContext) EvalContext {
+	mode, ok := modeOf(caller)
+	if !ok {
+		return ctx
+	}
+
+	for c := ctx; c != nil; c, _ = parentOf(c) {
+		if c == EvalContext(mode) {
+			return ctx
+		}
+	}
+
+	return mode.withParent(ctx)
+}
@@ -0,0 +1,319 @@
+package calculon
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Associativity tells how a chain of operators of the same binding power
+// is grouped: a - b - c is (a - b) - c, but a ^ b ^ c is a ^ (b ^ c).
+type Associativity int
+
+const (
+	LeftAssoc Associativity = iota
+	RightAssoc
+)
+
+// Binding powers of the predefined operators, from the loosest to the tightest.
+// The gaps between them leave room for user-registered operators.
+const (
+	PrecConditional = 10 * (iota + 1) // c ? a : b
+	PrecOr                            // ||
+	PrecXor                           // xor
+	PrecAnd                           // &&
+	PrecEquality                      // == !=
+	PrecComparison                    // < <= > >=
+	PrecSum                           // + -
+	PrecTerm                          // * / %
+	PrecPrefix                        // -x +x !x
+	PrecPower                         // ^
+	PrecPostfix                       // x! x%
+
+	// implicit products bind tighter than explicit ones: 1/2x is 1/(2*x)
+	precImplicitMul = PrecTerm + 5
+	precPrimary     = 1000
+)
+
+// InfixFunc evaluates a binary operator.
+type InfixFunc = func(left, right float64) (float64, error)
+
+// UnaryFunc evaluates a prefix or postfix operator.
+type UnaryFunc = func(x float64) (float64, error)
+
+// ValueInfixFunc evaluates a binary operator on values of particular kinds.
+type ValueInfixFunc = func(left, right Value) (Value, error)
+
+// ValueUnaryFunc evaluates a prefix or postfix operator on a value of a particular kind.
+type ValueUnaryFunc = func(x Value) (Value, error)
+
+type infixOperator struct {
+	power int
+	assoc Associativity
+	eval  InfixFunc
+}
+
+type unaryOperator struct {
+	power int
+	eval  UnaryFunc
+}
+
+type infixKey struct {
+	op          string
+	left, right Kind
+}
+
+type unaryKey struct {
+	op      string
+	postfix bool
+	kind    Kind
+}
+
+var (
+	infixOps = map[string]infixOperator{
+		"||":  {PrecOr, LeftAssoc, func(l, r float64) (float64, error) { return truth(l != 0 || r != 0), nil }},
+		"xor": {PrecXor, LeftAssoc, func(l, r float64) (float64, error) { return truth((l != 0) != (r != 0)), nil }},
+		"&&":  {PrecAnd, LeftAssoc, func(l, r float64) (float64, error) { return truth(l != 0 && r != 0), nil }},
+		"==":  {PrecEquality, LeftAssoc, func(l, r float64) (float64, error) { return truth(l == r), nil }},
+		"!=":  {PrecEquality, LeftAssoc, func(l, r float64) (float64, error) { return truth(l != r), nil }},
+		"<":   {PrecComparison, LeftAssoc, func(l, r float64) (float64, error) { return truth(l < r), nil }},
+		"<=":  {PrecComparison, LeftAssoc, func(l, r float64) (float64, error) { return truth(l <= r), nil }},
+		">":   {PrecComparison, LeftAssoc, func(l, r float64) (float64, error) { return truth(l > r), nil }},
+		">=":  {PrecComparison, LeftAssoc, func(l, r float64) (float64, error) { return truth(l >= r), nil }},
+		"+":   {PrecSum, LeftAssoc, func(l, r float64) (float64, error) { return l + r, nil }},
+		"-":   {PrecSum, LeftAssoc, func(l, r float64) (float64, error) { return l - r, nil }},
+		"*":   {PrecTerm, LeftAssoc, func(l, r float64) (float64, error) { return l * r, nil }},
+		"/": {PrecTerm, LeftAssoc, func(l, r float64) (float64, error) {
+			if r == 0 {
+				return 0, fmt.Errorf("divide by zero")
+			}
+
+			return l / r, nil
+		}},
+		"%": {PrecTerm, LeftAssoc, func(l, r float64) (float64, error) { return math.Mod(l, r), nil }},
+		"^": {PrecPower, RightAssoc, func(l, r float64) (float64, error) { return math.Pow(l, r), nil }},
+	}
+
+	prefixOps = map[string]unaryOperator{
+		"+": {PrecPrefix, func(x float64) (float64, error) { return x, nil }},
+		"-": {PrecPrefix, func(x float64) (float64, error) { return -x, nil }},
+		"!": {PrecPrefix, func(x float64) (float64, error) { return truth(x == 0), nil }},
+	}
+
+	postfixOps = map[string]unaryOperator{
+		"!": {PrecPostfix, factorial},
+		// percent, 15% is 0.15
+		"%": {PrecPostfix, func(x float64) (float64, error) { return x / 100, nil }},
+	}
+
+	// operators on values other than numbers and vectors, by the kinds of operands
+	infixValueOps = map[infixKey]ValueInfixFunc{
+		{"&&", KindBool, KindBool}:     func(l, r Value) (Value, error) { return l.(Bool) && r.(Bool), nil },
+		{"||", KindBool, KindBool}:     func(l, r Value) (Value, error) { return l.(Bool) || r.(Bool), nil },
+		{"xor", KindBool, KindBool}:    func(l, r Value) (Value, error) { return Bool(l.(Bool) != r.(Bool)), nil },
+		{"==", KindBool, KindBool}:     func(l, r Value) (Value, error) { return Bool(l.(Bool) == r.(Bool)), nil },
+		{"!=", KindBool, KindBool}:     func(l, r Value) (Value, error) { return Bool(l.(Bool) != r.(Bool)), nil },
+		{"+", KindString, KindString}:  func(l, r Value) (Value, error) { return l.(String) + r.(String), nil },
+		{"==", KindString, KindString}: func(l, r Value) (Value, error) { return Bool(l.(String) == r.(String)), nil },
+		{"!=", KindString, KindString}: func(l, r Value) (Value, error) { return Bool(l.(String) != r.(String)), nil },
+		{"<", KindString, KindString}:  func(l, r Value) (Value, error) { return Bool(l.(String) < r.(String)), nil },
+		{">", KindString, KindString}:  func(l, r Value) (Value, error) { return Bool(l.(String) > r.(String)), nil },
+	}
+
+	unaryValueOps = map[unaryKey]ValueUnaryFunc{
+		{"!", false, KindBool}: func(x Value) (Value, error) { return !x.(Bool), nil },
+	}
+
+	// operatorSymbols lists the non-word symbols of all operators,
+	// longest first, so that the lexer prefers <= over <.
+	operatorSymbols []string
+)
+
+func init() {
+	updateOperatorSymbols()
+}
+
+// RegisterInfix defines a binary operator, or redefines an existing one.
+// Operators with a higher binding power bind tighter, see the Prec constants
+// for the powers of the predefined operators. fn may be nil for operators
+// that only apply to other values than numbers, see RegisterInfixValue.
+//
+// The symbol is either a sequence of punctuation characters, such as << or ..,
+// or a name, such as mod. Registration is not safe for concurrent use with
+// parsing or evaluation, so it should be done at program initialization.
+func RegisterInfix(symbol string, power int, assoc Associativity, fn InfixFunc) {
+	checkOperatorSymbol(symbol)
+	infixOps[symbol] = infixOperator{power: power, assoc: assoc, eval: fn}
+	updateOperatorSymbols()
+}
+
+// RegisterPrefix defines an operator written before its operand, such as ± or not.
+// The operand extends over all operators binding tighter than power.
+// See RegisterInfix for the restrictions on symbol.
+func RegisterPrefix(symbol string, power int, fn UnaryFunc) {
+	checkOperatorSymbol(symbol)
+	prefixOps[symbol] = unaryOperator{power: power, eval: fn}
+	updateOperatorSymbols()
+}
+
+// RegisterPostfix defines an operator written after its operand, such as !.
+// When a symbol is both an infix and a postfix operator, it is read as infix
+// if something that can start an operand follows it.
+// See RegisterInfix for the restrictions on symbol.
+func RegisterPostfix(symbol string, power int, fn UnaryFunc) {
+	checkOperatorSymbol(symbol)
+	postfixOps[symbol] = unaryOperator{power: power, eval: fn}
+	updateOperatorSymbols()
+}
+
+// RegisterInfixValue defines what an infix operator does for operands of the given kinds:
+//
+//	RegisterInfixValue("+", KindMoney, KindMoney, addMoney)
+//
+// It takes precedence over the numeric meaning of the operator. The operator
+// itself has to be defined already, either predefined or with RegisterInfix.
+// Registration is not safe for concurrent use with evaluation.
+func RegisterInfixValue(op string, left, right Kind, fn ValueInfixFunc) {
+	if _, found := infixOps[op]; !found {
+		panic("calculon: unknown infix operator: " + op)
+	}
+
+	infixValueOps[infixKey{op: op, left: left, right: right}] = fn
+}
+
+// RegisterPrefixValue defines what a prefix operator does for an operand of the given kind.
+// See RegisterInfixValue.
+func RegisterPrefixValue(op string, kind Kind, fn ValueUnaryFunc) {
+	if _, found := prefixOps[op]; !found {
+		panic("calculon: unknown prefix operator: " + op)
+	}
+
+	unaryValueOps[unaryKey{op: op, kind: kind}] = fn
+}
+
+// RegisterPostfixValue defines what a postfix operator does for an operand of the given kind.
+// See RegisterInfixValue.
+func RegisterPostfixValue(op string, kind Kind, fn ValueUnaryFunc) {
+	if _, found := postfixOps[op]; !found {
+		panic("calculon: unknown postfix operator: " + op)
+	}
+
+	unaryValueOps[unaryKey{op: op, postfix: true, kind: kind}] = fn
+}
+
+// evalInfix applies a binary operator to values. Operators registered for the kinds
+// of operands come first, otherwise numbers and vectors get the numeric meaning.
+func evalInfix(op string, l, r Value) (Value, error) {
+	if fn, found := infixValueOps[infixKey{op: op, left: l.Kind(), right: r.Kind()}]; found {
+		return fn(l, r)
+	}
+
+	operator, found := infixOps[op]
+	if !found {
+		return nil, fmt.Errorf("unexpected binary op: %s", op)
+	}
+
+	left, lok := numericValue(l)
+	right, rok := numericValue(r)
+	if operator.eval == nil || !lok || !rok {
+		return nil, fmt.Errorf("operator %s is not defined for %s and %s", op, l.Kind(), r.Kind())
+	}
+
+	return mapBinary(operator.eval, left, right)
+}
+
+// evalUnary applies a prefix or postfix operator to a value, see evalInfix.
+func evalUnary(op string, postfix bool, x Value) (Value, error) {
+	if fn, found := unaryValueOps[unaryKey{op: op, postfix: postfix, kind: x.Kind()}]; found {
+		return fn(x)
+	}
+
+	ops := prefixOps
+	if postfix {
+		ops = postfixOps
+	}
+
+	operator, found := ops[op]
+	if !found {
+		return nil, fmt.Errorf("unexpected unary op: %s", op)
+	}
+
+	val, ok := numericValue(x)
+	if operator.eval == nil || !ok {
+		return nil, fmt.Errorf("operator %s is not defined for %s", op, x.Kind())
+	}
+
+	return mapUnary(operator.eval, val)
+}
+
+// numericValue returns val if it is a number or a vector, or the number it stands for.
+func numericValue(val Value) (Value, bool) {
+	if kind := val.Kind(); kind == KindNumber || kind == KindVector {
+		return val, true
+	}
+
+	return realValue(val)
+}
+
+func checkOperatorSymbol(symbol string) {
+	valid := symbol != "" && symbol != "=" && !strings.HasPrefix(symbol, "->")
+	if isWordOperator(symbol) {
+		// named operators must be valid identifiers
+		for _, r := range symbol {
+			valid = valid && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_')
+		}
+	} else {
+		for _, r := range symbol {
+			valid = valid && !unicode.IsSpace(r) && !unicode.IsLetter(r) && !unicode.IsDigit(r) &&
+				!strings.ContainsRune("_()[],?:;", r)
+		}
+	}
+
+	if !valid {
+		panic("calculon: invalid operator symbol: " + symbol)
+	}
+}
+
+// isWordOperator reports whether the operator is a name, like xor, rather than punctuation.
+func isWordOperator(symbol string) bool {
+	first, _ := utf8.DecodeRuneInString(symbol)
+	return unicode.IsLetter(first) || first == '_'
+}
+
+func updateOperatorSymbols() {
+	seen := make(map[string]bool)
+
+	var symbols []string
+	add := func(symbol string) {
+		// named operators are lexed as identifiers
+		if !seen[symbol] && !isWordOperator(symbol) {
+			seen[symbol] = true
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	for symbol := range infixOps {
+		add(symbol)
+	}
+
+	for symbol := range prefixOps {
+		add(symbol)
+	}
+
+	for symbol := range postfixOps {
+		add(symbol)
+	}
+
+	sort.Slice(symbols, func(i, j int) bool {
+		if len(symbols[i]) != len(symbols[j]) {
+			return len(symbols[i]) > len(symbols[j])
+		}
+
+		return symbols[i] < symbols[j]
+	})
+
+	operatorSymbols = symbols
+}
@@ -1,20 +1,57 @@
 package calculon
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/xjem/calculon/internal/lexer"
 )
 
+// Options configure parsing.
+type Options struct {
+	// ImplicitMul allows to omit '*' after a number or a closing paren
+	// when an identifier or an opening paren follows: 2x, 3(x+1), (a)(b), 2 sin(x).
+	// Implicit products bind tighter than explicit ones, so 1/2x is 1/(2*x).
+	ImplicitMul bool
+}
+
 type parser struct {
+	input string
+	opts  Options
 	lexer *lexer.Lexer
+	prev  lexer.Token // last consumed token
+
+	// in programs a newline outside of parens ends the statement
+	program bool
+	depth   int
+
+	// recovering parsers record errors in errs and carry on instead of failing
+	recovering bool
+	errs       []*ParseError
 }
 
-func newParser(input string) *parser {
+func newParser(input string, opts Options) *parser {
 	return &parser{
-		lexer: lexer.New(input),
+		input: input,
+		opts:  opts,
+		lexer: lexer.New(input, operatorSymbols...),
+	}
+}
+
+func (p *parser) next() Token {
+	p.prev = p.lexer.Next()
+	return p.prev
+}
+
+func (p *parser) eat(kind lexer.Kind) bool {
+	if p.lexer.Ahead().Kind != kind {
+		return false
 	}
+
+	_ = p.next()
+	return true
 }
 
 func (p *parser) parse() (Expression, error) {
@@ -23,129 +60,505 @@ func (p *parser) parse() (Expression, error) {
 		return nil, err
 	}
 
-	if next := p.lexer.Next(); next.Kind != lexer.EOF {
-		return nil, fmt.Errorf("unexpected token: %s", next)
+	if next := p.next(); next.Kind != lexer.EOF {
+		return nil, p.unexpected(next)
 	}
 
 	return expr, nil
 }
 
+func (p *parser) parseProgram() (*Program, error) {
+	p.program = true
+
+	prog := &Program{}
+	for {
+		for p.eat(lexer.Semicolon) {
+		}
+
+		if p.lexer.Ahead().Kind == lexer.EOF {
+			return prog, nil
+		}
+
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+
+		prog.Statements = append(prog.Statements, stmt)
+
+		next := p.lexer.Ahead()
+		if next.Kind != lexer.EOF && next.Kind != lexer.Semicolon && !p.newline(next) {
+			return nil, p.unexpected(next, lexer.Semicolon)
+		}
+	}
+}
+
+// parseStatement parses an expression, an assignment x = ... or
+// a function definition f(a, b) = ...
+func (p *parser) parseStatement() (Expression, error) {
+	target, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	assign := p.lexer.Ahead()
+	if assign.Kind != lexer.Assign {
+		return target, nil
+	}
+
+	_ = p.next() // =
+	value, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.define(target, assign, value)
+}
+
+// define makes an assignment x = value or a function definition f(a, b) = value.
+func (p *parser) define(target Expression, assign Token, value Expression) (Expression, error) {
+	var params []string
+	switch target := target.(type) {
+	case Variable:
+	case FunctionCall:
+		for _, arg := range target.Args {
+			param, ok := arg.(Variable)
+			if !ok {
+				return nil, p.errorf(assign, "invalid parameter %s of %s", arg, target.Name)
+			}
+
+			params = append(params, param.Name)
+		}
+
+		if err := p.checkParams(target.Name, params, assign); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, p.errorf(assign, "cannot assign to %s", target)
+	}
+
+	loc := join(target.Span(), value.Span())
+	if call, ok := target.(FunctionCall); ok {
+		return FunctionDef{Name: call.Name, Params: params, Body: value, Loc: loc}, nil
+	}
+
+	return Assignment{Name: target.(Variable).Name, Value: value, Loc: loc}, nil
+}
+
+// checkParams rejects qualified and repeated parameter names of a function.
+func (p *parser) checkParams(owner string, params []string, at Token) error {
+	seen := make(map[string]bool)
+	for _, param := range params {
+		if strings.Contains(param, ".") {
+			return p.errorf(at, "invalid parameter %s of %s", param, owner)
+		}
+
+		if seen[param] {
+			return p.errorf(at, "duplicate parameter %s of %s", param, owner)
+		}
+
+		seen[param] = true
+	}
+
+	return nil
+}
+
+// newline reports whether tok ends the current statement of a program
+// by starting on a new line.
+func (p *parser) newline(tok Token) bool {
+	return p.program && p.depth == 0 && tok.Span.Start.Line > p.prev.Span.End.Line
+}
+
+func (p *parser) parseAll() (Expression, []*ParseError) {
+	p.recovering = true
+
+	expr, _ := p.parseExpr()
+	for next := p.lexer.Ahead(); next.Kind != lexer.EOF; next = p.lexer.Ahead() {
+		p.record(p.unexpected(next))
+		_ = p.next()
+
+		// look for more errors in whatever follows the stray token
+		for next = p.lexer.Ahead(); next.Kind != lexer.EOF && !startsExpr(next); next = p.lexer.Ahead() {
+			_ = p.next()
+		}
+
+		if next.Kind != lexer.EOF {
+			_, _ = p.parseExpr()
+		}
+	}
+
+	return expr, p.errs
+}
+
 func (p *parser) parseExpr() (Expression, error) {
-	expr, err := p.parseTerm()
+	expr, err := p.parseBinding(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.lexer.Ahead(); isKeyword(tok, "where") && !p.newline(tok) {
+		return p.parseWhere(expr)
+	}
+
+	return expr, nil
+}
+
+// parseLet parses let a = 1, b = a + 1 in body.
+func (p *parser) parseLet() (Expression, error) {
+	let := p.next()
+
+	bindings, err := p.parseBindings()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.lexer.Ahead(); !isKeyword(tok, "in") {
+		err := p.unexpected(tok, lexer.Ident)
+		err.Msg = "expected 'in', found " + describe(tok)
+		if err := p.fail(err); err != nil {
+			return nil, err
+		}
+	} else {
+		_ = p.next()
+	}
+
+	body, err := p.parseExpr()
 	if err != nil {
 		return nil, err
 	}
 
+	return Let{Bindings: bindings, Body: body, Loc: join(let.Span, body.Span())}, nil
+}
+
+// parseWhere parses the bindings of body where a = 1, b = a + 1.
+func (p *parser) parseWhere(body Expression) (Expression, error) {
+	_ = p.next() // where
+
+	bindings, err := p.parseBindings()
+	if err != nil {
+		return nil, err
+	}
+
+	return Let{
+		Bindings: bindings,
+		Body:     body,
+		Where:    true,
+		Loc:      join(body.Span(), bindings[len(bindings)-1].Span()),
+	}, nil
+}
+
+// parseBindings parses a comma separated list of assignments and function definitions.
+func (p *parser) parseBindings() ([]Expression, error) {
+	var bindings []Expression
 	for {
-		next := p.lexer.Ahead().Kind
-		if next == lexer.Plus || next == lexer.Minus {
-			_ = p.lexer.Next()
-			right, err := p.parseTerm()
-			if err != nil {
+		target, err := p.parseBinding(0)
+		if err != nil {
+			return nil, err
+		}
+
+		assign, err := p.expect(lexer.Assign)
+		if err != nil {
+			if err := p.fail(err); err != nil {
 				return nil, err
 			}
+		}
+
+		value, err := p.parseBinding(0)
+		if err != nil {
+			return nil, err
+		}
 
-			expr = BinaryOp{
-				Op:    next.String(),
-				Left:  expr,
-				Right: right,
+		binding, err := p.define(target, assign, value)
+		if err != nil {
+			if err := p.fail(err); err != nil {
+				return nil, err
 			}
 
-			continue
+			binding = Bad{Loc: join(target.Span(), value.Span())}
 		}
 
-		return expr, nil
+		bindings = append(bindings, binding)
+		if !p.eat(lexer.Comma) {
+			return bindings, nil
+		}
 	}
 }
 
-func (p *parser) parseTerm() (Expression, error) {
-	left, err := p.parseFactor()
+// parseBinding parses an expression made of operators that bind at least as
+// tight as minPower. This is a Pratt parser, the binding powers of operators
+// come from the operator tables.
+func (p *parser) parseBinding(minPower int) (Expression, error) {
+	left, err := p.parsePrefix()
 	if err != nil {
 		return nil, err
 	}
 
 	for {
-		next := p.lexer.Ahead().Kind
-		if next == lexer.Asterisk || next == lexer.Slash || next == lexer.Percent {
-			_ = p.lexer.Next()
-			right, err := p.parseFactor()
+		next := p.lexer.Ahead()
+		if p.newline(next) {
+			return left, nil
+		}
+
+		if next.Kind == lexer.OpenBracket {
+			left, err = p.parseIndex(left)
 			if err != nil {
 				return nil, err
 			}
 
-			left = BinaryOp{
-				Op:    next.String(),
-				Left:  left,
-				Right: right,
+			continue
+		}
+
+		if op, found := p.postfixOp(next); found {
+			if op.power < minPower {
+				return left, nil
+			}
+
+			_ = p.next()
+			left = UnaryOp{
+				Op:        next.Value,
+				Expr:      left,
+				IsPostfix: true,
+				Loc:       join(left.Span(), next.Span),
 			}
 
 			continue
 		}
 
-		return left, nil
+		if next.Kind == lexer.Question {
+			if PrecConditional < minPower {
+				return left, nil
+			}
+
+			left, err = p.parseConditional(left)
+			if err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		power, rightPower, found := p.infixPower(next)
+		if !found || power < minPower {
+			return left, nil
+		}
+
+		op := "*"
+		if power != precImplicitMul {
+			op = next.Value
+			_ = p.next()
+		}
+
+		right, err := p.parseBinding(rightPower)
+		if err != nil {
+			return nil, err
+		}
+
+		left = BinaryOp{
+			Op:    op,
+			Left:  left,
+			Right: right,
+			Loc:   join(left.Span(), right.Span()),
+		}
 	}
 }
 
-func (p *parser) parseFactor() (Expression, error) {
-	if p.lexer.Eat(lexer.Plus) {
-		return p.parseFactor()
+// infixPower returns the binding power of tok as an infix operator and
+// the minimal binding power of its right operand.
+func (p *parser) infixPower(tok Token) (power, rightPower int, found bool) {
+	if p.implicitMul(tok) {
+		return precImplicitMul, precImplicitMul + 1, true
 	}
 
-	if p.lexer.Eat(lexer.Minus) {
-		expr, err := p.parseFactor()
-		if err != nil {
+	if tok.Kind != lexer.Operator && tok.Kind != lexer.Ident {
+		return 0, 0, false
+	}
+
+	op, found := infixOps[tok.Value]
+	if !found {
+		return 0, 0, false
+	}
+
+	if op.assoc == RightAssoc {
+		return op.power, op.power, true
+	}
+
+	return op.power, op.power + 1, true
+}
+
+// implicitMul reports whether tok starts the second factor of an implicit product.
+func (p *parser) implicitMul(tok Token) bool {
+	if !p.opts.ImplicitMul {
+		return false
+	}
+
+	if prev := p.prev.Kind; prev != lexer.Number && prev != lexer.Imaginary && prev != lexer.CloseParen {
+		return false
+	}
+
+	return tok.Kind == lexer.OpenParen || (tok.Kind == lexer.Ident && !isOperator(tok) && !isKeyword(tok, "let", "in", "where"))
+}
+
+func (p *parser) postfixOp(tok Token) (unaryOperator, bool) {
+	if tok.Kind != lexer.Operator && tok.Kind != lexer.Ident {
+		return unaryOperator{}, false
+	}
+
+	op, found := postfixOps[tok.Value]
+	if !found {
+		return unaryOperator{}, false
+	}
+
+	// an operator between two operands is infix: x % y
+	if _, infix := infixOps[tok.Value]; infix && startsExpr(p.lexer.Peek(1)) {
+		return unaryOperator{}, false
+	}
+
+	return op, true
+}
+
+func (p *parser) parsePrefix() (Expression, error) {
+	tok := p.lexer.Ahead()
+	if tok.Kind != lexer.Operator && tok.Kind != lexer.Ident {
+		return p.parsePrimary()
+	}
+
+	op, found := prefixOps[tok.Value]
+	if !found {
+		return p.parsePrimary()
+	}
+
+	_ = p.next()
+	expr, err := p.parseBinding(op.power)
+	if err != nil {
+		return nil, err
+	}
+
+	return UnaryOp{Op: tok.Value, Expr: expr, Loc: join(tok.Span, expr.Span())}, nil
+}
+
+// parseIndex parses the brackets of v[i].
+func (p *parser) parseIndex(expr Expression) (Expression, error) {
+	_ = p.next() // [
+
+	p.depth++
+	index, err := p.parseExpr()
+	p.depth--
+	if err != nil {
+		return nil, err
+	}
+
+	closing, err := p.expect(lexer.CloseBracket)
+	if err != nil {
+		if err := p.fail(err); err != nil {
 			return nil, err
 		}
 
-		return UnaryOp{Op: "-", Expr: expr}, nil
+		closing.Span = index.Span()
 	}
 
-	expr, err := p.parsePrimary()
+	return Index{Expr: expr, Index: index, Loc: join(expr.Span(), closing.Span)}, nil
+}
+
+func (p *parser) parseConditional(cond Expression) (Expression, error) {
+	_ = p.next() // ?
+
+	then, err := p.parseExpr()
 	if err != nil {
 		return nil, err
 	}
 
-	if p.lexer.Eat(lexer.Caret) {
-		power, err := p.parseFactor()
-		if err != nil {
+	if _, err := p.expect(lexer.Colon); err != nil {
+		if err := p.fail(err); err != nil {
 			return nil, err
 		}
+	}
 
-		return BinaryOp{
-			Op:    "^",
-			Left:  expr,
-			Right: power,
-		}, nil
+	els, err := p.parseBinding(PrecConditional)
+	if err != nil {
+		return nil, err
 	}
 
-	return expr, nil
+	return Conditional{
+		Cond: cond,
+		Then: then,
+		Else: els,
+		Loc:  join(cond.Span(), els.Span()),
+	}, nil
 }
 
 func (p *parser) parsePrimary() (Expression, error) {
-	tok := p.lexer.Next()
+	tok := p.lexer.Ahead()
 	switch tok.Kind {
 	case lexer.OpenParen:
+		if p.isLambda() {
+			return p.parseLambda()
+		}
+
+		_ = p.next()
+		p.depth++
 		expr, err := p.parseExpr()
+		p.depth--
 		if err != nil {
 			return nil, err
 		}
 
-		if !p.lexer.Eat(lexer.CloseParen) {
-			return nil, fmt.Errorf("expected: ')'")
+		closing, err := p.expect(lexer.CloseParen)
+		if err != nil {
+			if err := p.fail(err); err != nil {
+				return nil, err
+			}
+
+			closing.Span = expr.Span()
 		}
 
-		return Parentheses{Expr: expr}, nil
-	case lexer.Number:
-		num, err := strconv.ParseFloat(tok.Value, 64)
+		return Parentheses{Expr: expr, Loc: join(tok.Span, closing.Span)}, nil
+	case lexer.OpenBracket:
+		_ = p.next()
+		p.depth++
+		items, closing, err := p.parseArgs(lexer.CloseBracket)
+		p.depth--
 		if err != nil {
 			return nil, err
 		}
 
-		return Number{Value: num}, nil
+		return List{Items: items, Loc: join(tok.Span, closing.Span)}, nil
+	case lexer.Number:
+		_ = p.next()
+		num, err := parseNumber(tok.Value)
+		if err != nil {
+			return p.bad(p.errorf(tok, "%s", err))
+		}
+
+		return Number{Value: num, Literal: tok.Value, Loc: tok.Span}, nil
+	case lexer.Imaginary:
+		_ = p.next()
+		num, err := parseNumber(strings.TrimSuffix(tok.Value, "i"))
+		if err != nil {
+			return p.bad(p.errorf(tok, "%s", err))
+		}
+
+		return Imaginary{Value: num, Literal: tok.Value, Loc: tok.Span}, nil
 	case lexer.Ident:
+		if isOperator(tok) {
+			return p.bad(p.unexpected(tok, lexer.Number, lexer.Ident, lexer.OpenParen))
+		}
+
+		if p.lexer.Peek(1).Kind == lexer.Arrow {
+			return p.parseLambda()
+		}
+
+		if isKeyword(tok, "let") && p.lexer.Peek(1).Kind == lexer.Ident {
+			if next := p.lexer.Peek(2).Kind; next == lexer.Assign || next == lexer.OpenParen {
+				return p.parseLet()
+			}
+		}
+
+		_ = p.next()
 		// it's a function?
-		if p.lexer.Eat(lexer.OpenParen) {
-			args, err := p.parseArgs()
+		if p.eat(lexer.OpenParen) {
+			p.depth++
+			args, closing, err := p.parseArgs(lexer.CloseParen)
+			p.depth--
 			if err != nil {
 				return nil, err
 			}
@@ -153,35 +566,301 @@ func (p *parser) parsePrimary() (Expression, error) {
 			return FunctionCall{
 				Name: tok.Value,
 				Args: args,
+				Loc:  join(tok.Span, closing.Span),
 			}, nil
 		}
 
-		return Variable{Name: tok.Value}, nil
+		return Variable{Name: tok.Value, Loc: tok.Span}, nil
 	default:
-		return nil, fmt.Errorf("unexpected token: %s", tok)
+		// tokens the caller can resync on are left in place
+		if !isSync(tok) {
+			p.skip(isSync)
+		}
+
+		return p.bad(p.unexpected(tok, lexer.Number, lexer.Ident, lexer.OpenParen, lexer.OpenBracket, lexer.Operator))
+	}
+}
+
+// isLambda reports whether the parens ahead hold the parameters of a lambda: (a, b) -> ...
+func (p *parser) isLambda() bool {
+	if p.lexer.Peek(1).Kind == lexer.CloseParen {
+		return p.lexer.Peek(2).Kind == lexer.Arrow
+	}
+
+	for i := 1; ; i += 2 {
+		if tok := p.lexer.Peek(i); tok.Kind != lexer.Ident || isOperator(tok) {
+			return false
+		}
+
+		switch p.lexer.Peek(i + 1).Kind {
+		case lexer.Comma:
+			continue
+		case lexer.CloseParen:
+			return p.lexer.Peek(i+2).Kind == lexer.Arrow
+		default:
+			return false
+		}
+	}
+}
+
+// parseLambda parses x -> body or (a, b) -> body, the parameters are known to be well-formed.
+func (p *parser) parseLambda() (Expression, error) {
+	start := p.next()
+
+	var params []string
+	if start.Kind == lexer.Ident {
+		params = append(params, start.Value)
+	} else {
+		for tok := p.next(); tok.Kind != lexer.CloseParen; tok = p.next() {
+			if tok.Kind == lexer.Ident {
+				params = append(params, tok.Value)
+			}
+		}
+	}
+
+	arrow := p.next()
+	if err := p.checkParams("lambda", params, arrow); err != nil {
+		if err := p.fail(err); err != nil {
+			return nil, err
+		}
+	}
+
+	body, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	return Lambda{Params: params, Body: body, Loc: join(start.Span, body.Span())}, nil
+}
+
+// parseNumber converts a number literal to its value.
+func parseNumber(literal string) (float64, error) {
+	var (
+		num float64
+		err error
+	)
+
+	if len(literal) > 2 && literal[0] == '0' && strings.ContainsRune("xXoObB", rune(literal[1])) {
+		var n uint64
+		n, err = strconv.ParseUint(literal, 0, 64)
+		num = float64(n)
+	} else {
+		num, err = strconv.ParseFloat(literal, 64)
+	}
+
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, fmt.Errorf("number literal out of range: %s", literal)
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid number literal: %s", literal)
 	}
+
+	return num, nil
 }
 
-func (p *parser) parseArgs() ([]Expression, error) {
+// parseArgs parses call arguments or vector items after the opening paren
+// or bracket, and returns them together with the closing token.
+func (p *parser) parseArgs(closing lexer.Kind) ([]Expression, Token, error) {
 	var args []Expression
-	for !p.lexer.Eat(lexer.CloseParen) {
+	for {
+		next := p.lexer.Ahead()
+		if next.Kind == closing {
+			return args, p.next(), nil
+		}
+
+		if next.Kind == lexer.EOF && len(args) > 0 {
+			_, err := p.expect(closing, lexer.Comma)
+			return args, next, p.fail(err)
+		}
+
 		if len(args) > 0 {
-			if !p.lexer.Eat(lexer.Comma) {
-				return nil, fmt.Errorf("expected ','")
+			if _, err := p.expect(lexer.Comma, closing); err != nil {
+				if err := p.fail(err); err != nil {
+					return nil, Token{}, err
+				}
+
+				p.skip(func(tok Token) bool {
+					return tok.Kind == lexer.Comma || tok.Kind == closing
+				})
+
+				continue
 			}
 		}
 
 		expr, err := p.parseExpr()
 		if err != nil {
-			return nil, err
+			return nil, Token{}, err
 		}
 
 		args = append(args, expr)
 	}
+}
+
+// fail records err and lets a recovering parser go on, otherwise it returns err.
+func (p *parser) fail(err error) error {
+	if !p.recovering {
+		return err
+	}
 
-	return args, nil
+	p.record(err.(*ParseError))
+	return nil
 }
 
+// bad is like fail, but also returns a Bad placeholder to put into the tree.
+func (p *parser) bad(err *ParseError) (Expression, error) {
+	if err := p.fail(err); err != nil {
+		return nil, err
+	}
+
+	return Bad{Loc: err.Span}, nil
+}
+
+func (p *parser) record(err *ParseError) {
+	// a single mistake tends to be noticed by several rules at the same place
+	if n := len(p.errs); n > 0 && p.errs[n-1].Span.Start == err.Span.Start {
+		return
+	}
+
+	p.errs = append(p.errs, err)
+}
+
+// skip drops tokens until stop matches the next one, stepping over
+// parenthesized and bracketed groups as a whole.
+func (p *parser) skip(stop func(tok Token) bool) {
+	depth := 0
+	for {
+		tok := p.lexer.Ahead()
+		if tok.Kind == lexer.EOF || (depth == 0 && stop(tok)) {
+			return
+		}
+
+		switch tok.Kind {
+		case lexer.OpenParen, lexer.OpenBracket:
+			depth++
+		case lexer.CloseParen, lexer.CloseBracket:
+			depth--
+		}
+
+		_ = p.next()
+	}
+}
+
+// isSync reports whether a recovering parser can resume at tok.
+func isSync(tok Token) bool {
+	switch tok.Kind {
+	case lexer.Comma, lexer.CloseParen, lexer.CloseBracket, lexer.EOF, lexer.Question, lexer.Colon:
+		return true
+	case lexer.Operator, lexer.Ident:
+		_, infix := infixOps[tok.Value]
+		return infix
+	default:
+		return false
+	}
+}
+
+// startsExpr reports whether tok can be the first token of an expression.
+func startsExpr(tok Token) bool {
+	switch tok.Kind {
+	case lexer.Number, lexer.Imaginary, lexer.OpenParen, lexer.OpenBracket:
+		return true
+	case lexer.Ident:
+		_, prefix := prefixOps[tok.Value]
+		return prefix || !isOperator(tok)
+	case lexer.Operator:
+		_, prefix := prefixOps[tok.Value]
+		return prefix
+	default:
+		return false
+	}
+}
+
+// isKeyword reports whether tok is one of the given keywords. Keywords are only
+// special where the grammar expects them, elsewhere they are ordinary names.
+func isKeyword(tok Token, keywords ...string) bool {
+	if tok.Kind != lexer.Ident {
+		return false
+	}
+
+	for _, keyword := range keywords {
+		if tok.Value == keyword {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isOperator reports whether tok is an operator symbol or name.
+func isOperator(tok Token) bool {
+	if tok.Kind == lexer.Operator {
+		return true
+	}
+
+	if tok.Kind != lexer.Ident {
+		return false
+	}
+
+	_, infix := infixOps[tok.Value]
+	_, prefix := prefixOps[tok.Value]
+	_, postfix := postfixOps[tok.Value]
+
+	return infix || prefix || postfix
+}
+
+// expect consumes the next token if it is of the first of the given kinds,
+// the rest are only reported as acceptable alternatives in the error.
+func (p *parser) expect(kind lexer.Kind, alternatives ...lexer.Kind) (Token, error) {
+	tok := p.lexer.Ahead()
+	if tok.Kind != kind {
+		err := p.unexpected(tok, append([]lexer.Kind{kind}, alternatives...)...)
+		err.Msg = "expected '" + kind.String() + "', found " + describe(tok)
+		return tok, err
+	}
+
+	return p.next(), nil
+}
+
+func (p *parser) unexpected(tok Token, expected ...lexer.Kind) *ParseError {
+	err := p.errorf(tok, "unexpected %s", describe(tok))
+	err.Expected = expected
+	return err
+}
+
+func (p *parser) errorf(tok Token, format string, args ...interface{}) *ParseError {
+	return &ParseError{
+		Span:  tok.Span,
+		Msg:   fmt.Sprintf(format, args...),
+		Found: tok,
+		input: p.input,
+	}
+}
+
+// join returns the smallest span covering both from and to.
+func join(from, to Span) Span {
+	return Span{Start: from.Start, End: to.End}
+}
+
+// Parse parses the input into an expression tree.
+// Syntax errors are reported as *ParseError.
 func Parse(input string) (Expression, error) {
-	return newParser(input).parse()
+	return ParseWithOptions(input, Options{})
+}
+
+// ParseWithOptions is like Parse, but the syntax can be tuned with opts.
+func ParseWithOptions(input string, opts Options) (Expression, error) {
+	return newParser(input, opts).parse()
+}
+
+// ParseAll is like Parse, but does not stop at the first syntax error.
+// It reports every error it finds and returns a partial tree in which
+// the unparsable parts are replaced with Bad nodes.
+func ParseAll(input string) (Expression, []*ParseError) {
+	return newParser(input, Options{}).parseAll()
+}
+
+// ParseProgram parses a sequence of statements separated by newlines or semicolons,
+// see Program. Inside parens an expression may span several lines.
+func ParseProgram(input string) (*Program, error) {
+	return newParser(input, Options{}).parseProgram()
 }
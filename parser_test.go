@@ -1,7 +1,6 @@
 package calculon
 
 import (
-	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -12,7 +11,7 @@ func TestParser(t *testing.T) {
 		name     string
 		input    string
 		expected Expression
-		err      error
+		err      string
 	}{
 		{
 			name:  "simple",
@@ -147,19 +146,964 @@ func TestParser(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:  "logic-precedence",
+			input: "temp > 80 && load >= 0.9 || !ok",
+			expected: BinaryOp{
+				Op: "||",
+				Left: BinaryOp{
+					Op: "&&",
+					Left: BinaryOp{
+						Op:    ">",
+						Left:  Variable{Name: "temp"},
+						Right: Number{Value: 80},
+					},
+					Right: BinaryOp{
+						Op:    ">=",
+						Left:  Variable{Name: "load"},
+						Right: Number{Value: 0.9},
+					},
+				},
+				Right: UnaryOp{Op: "!", Expr: Variable{Name: "ok"}},
+			},
+		},
+		{
+			name:  "equality-xor",
+			input: "a == b + 1 xor c != d < e",
+			expected: BinaryOp{
+				Op: "xor",
+				Left: BinaryOp{
+					Op:   "==",
+					Left: Variable{Name: "a"},
+					Right: BinaryOp{
+						Op:    "+",
+						Left:  Variable{Name: "b"},
+						Right: Number{Value: 1},
+					},
+				},
+				Right: BinaryOp{
+					Op:   "!=",
+					Left: Variable{Name: "c"},
+					Right: BinaryOp{
+						Op:    "<",
+						Left:  Variable{Name: "d"},
+						Right: Variable{Name: "e"},
+					},
+				},
+			},
+		},
+		{
+			name:  "nested-conditional",
+			input: "a ? b : c > 0 ? 1 : -1",
+			expected: Conditional{
+				Cond: Variable{Name: "a"},
+				Then: Variable{Name: "b"},
+				Else: Conditional{
+					Cond: BinaryOp{
+						Op:    ">",
+						Left:  Variable{Name: "c"},
+						Right: Number{Value: 0},
+					},
+					Then: Number{Value: 1},
+					Else: UnaryOp{Op: "-", Expr: Number{Value: 1}},
+				},
+			},
+		},
+		{
+			name:  "postfix",
+			input: "-3!^2 + 200 * 15%",
+			expected: BinaryOp{
+				Op: "+",
+				Left: UnaryOp{
+					Op: "-",
+					Expr: BinaryOp{
+						Op:    "^",
+						Left:  UnaryOp{Op: "!", Expr: Number{Value: 3}, IsPostfix: true},
+						Right: Number{Value: 2},
+					},
+				},
+				Right: BinaryOp{
+					Op:    "*",
+					Left:  Number{Value: 200},
+					Right: UnaryOp{Op: "%", Expr: Number{Value: 15}, IsPostfix: true},
+				},
+			},
+		},
+		{
+			name:  "percent-or-modulo",
+			input: "x % -y + x% - y + (x%) % y",
+			expected: BinaryOp{
+				Op: "+",
+				Left: BinaryOp{
+					Op: "+",
+					Left: BinaryOp{
+						Op:    "%",
+						Left:  Variable{Name: "x"},
+						Right: UnaryOp{Op: "-", Expr: Variable{Name: "y"}},
+					},
+					Right: BinaryOp{
+						Op:    "%",
+						Left:  Variable{Name: "x"},
+						Right: UnaryOp{Op: "-", Expr: Variable{Name: "y"}},
+					},
+				},
+				Right: BinaryOp{
+					Op: "%",
+					Left: Parentheses{
+						Expr: UnaryOp{Op: "%", Expr: Variable{Name: "x"}, IsPostfix: true},
+					},
+					Right: Variable{Name: "y"},
+				},
+			},
+		},
 		{
 			name:  "wrong1",
 			input: "f)(",
-			err:   fmt.Errorf("unexpected token: Kind:)"),
+			err:   "1:2: unexpected ')'",
 		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			expr, err := Parse(test.input)
-			assert.Equal(t, test.err, err)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, test.expected, stripSource(expr))
+		})
+	}
+}
+
+func TestParseNumbers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+		err      string
+	}{
+		{input: "42", expected: 42},
+		{input: "2.5", expected: 2.5},
+		{input: "2.", expected: 2},
+		{input: ".5", expected: 0.5},
+		{input: "1e-9", expected: 1e-9},
+		{input: "6.02E23", expected: 6.02e23},
+		{input: "1.5e+3", expected: 1500},
+		{input: "0xFF", expected: 255},
+		{input: "0XfF", expected: 255},
+		{input: "0o17", expected: 15},
+		{input: "0b1010", expected: 10},
+		{input: "1_000_000", expected: 1000000},
+		{input: "0x_dead_beef", expected: 0xdeadbeef},
+		{input: "3.141_592", expected: 3.141592},
+		{input: "007", expected: 7},
+		{input: "1__0", err: "1:1: invalid number literal: 1__0"},
+		{input: "1_", err: "1:1: invalid number literal: 1_"},
+		{input: "0x", err: "1:1: invalid number literal: 0x"},
+		{input: "0b102", err: "1:5: unexpected number 2"},
+		{input: "1e400", err: "1:1: number literal out of range: 1e400"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			expr, err := Parse(test.input)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+
+			if assert.NoError(t, err) {
+				assert.Equal(t, Number{Value: test.expected}, stripSource(expr))
+				assert.Equal(t, test.input, expr.String())
+			}
+		})
+	}
+}
+
+func TestNumberString(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{0, "0"},
+		{-3, "-3"},
+		{1533223, "1533223"},
+		{0.1, "0.1"},
+		{1.0 / 3, "0.3333333333333333"},
+		{1e-9, "1e-09"},
+		{6.02e23, "6.02e+23"},
+	}
+
+	for _, test := range tests {
+		str := Number{Value: test.value}.String()
+		assert.Equal(t, test.expected, str)
+
+		// what is printed must parse back to the same value
+		expr, err := Parse(str)
+		if assert.NoError(t, err) {
+			value, err := expr.Eval(EmptyContext{})
+			assert.NoError(t, err)
+			assert.Equal(t, test.value, value)
+		}
+	}
+}
+
+func TestParserSpans(t *testing.T) {
+	expr, err := Parse("1 +\n  foo(x, 2)")
+	assert.NoError(t, err)
+
+	binary := expr.(BinaryOp)
+	assert.Equal(t, Span{Start: pos(0, 1, 1), End: pos(15, 2, 12)}, binary.Span())
+
+	call := binary.Right.(FunctionCall)
+	assert.Equal(t, Span{Start: pos(6, 2, 3), End: pos(15, 2, 12)}, call.Span())
+	assert.Equal(t, Span{Start: pos(10, 2, 7), End: pos(11, 2, 8)}, call.Args[0].Span())
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		msg     string
+		snippet string
+	}{
+		{
+			name:    "unclosed-paren",
+			input:   "2 * (3 + 4",
+			msg:     "1:11: expected ')', found end of input",
+			snippet: "2 * (3 + 4\n          ^",
+		},
+		{
+			name:    "unexpected-char",
+			input:   "x +\n\tfoo($)",
+			msg:     "2:6: unexpected character $",
+			snippet: "\tfoo($)\n\t    ^",
+		},
+		{
+			name:    "missing-colon",
+			input:   "x ? 1",
+			msg:     "1:6: expected ':', found end of input",
+			snippet: "x ? 1\n     ^",
+		},
+		{
+			name:    "missing-comma",
+			input:   "foo(1 2)",
+			msg:     "1:7: expected ',', found number 2",
+			snippet: "foo(1 2)\n      ^",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(test.input)
+
+			perr, ok := err.(*ParseError)
+			if !assert.True(t, ok, "expected *ParseError, got %T", err) {
+				return
+			}
+
+			assert.EqualError(t, perr, test.msg)
+			assert.Equal(t, test.snippet, perr.Snippet())
+			assert.NotEmpty(t, perr.Expected)
+		})
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected Expression
+		errs     []string
+	}{
+		{
+			name:  "valid",
+			input: "2 + x",
+			expected: BinaryOp{
+				Op:    "+",
+				Left:  Number{Value: 2},
+				Right: Variable{Name: "x"},
+			},
+		},
+		{
+			name:  "missing-operand",
+			input: "2 + * 3",
+			expected: BinaryOp{
+				Op:   "+",
+				Left: Number{Value: 2},
+				Right: BinaryOp{
+					Op:    "*",
+					Left:  Bad{},
+					Right: Number{Value: 3},
+				},
+			},
+			errs: []string{"1:5: unexpected '*'"},
+		},
+		{
+			name:  "two-typos",
+			input: "foo($, 1) - bar(2 3, x)",
+			expected: BinaryOp{
+				Op:   "-",
+				Left: FunctionCall{Name: "foo", Args: []Expression{Bad{}, Number{Value: 1}}},
+				Right: FunctionCall{
+					Name: "bar",
+					Args: []Expression{Number{Value: 2}, Variable{Name: "x"}},
+				},
+			},
+			errs: []string{
+				"1:5: unexpected character $",
+				"1:19: expected ',', found number 3",
+			},
+		},
+		{
+			name:  "unclosed",
+			input: "(1 + sin(2",
+			expected: Parentheses{
+				Expr: BinaryOp{
+					Op:    "+",
+					Left:  Number{Value: 1},
+					Right: FunctionCall{Name: "sin", Args: []Expression{Number{Value: 2}}},
+				},
+			},
+			errs: []string{"1:11: expected ')', found end of input"},
+		},
+		{
+			name:     "stray-tokens",
+			input:    "x) + (y",
+			expected: Variable{Name: "x"},
+			errs: []string{
+				"1:2: unexpected ')'",
+				"1:8: expected ')', found end of input",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expr, errs := ParseAll(test.input)
+
+			var msgs []string
+			for _, err := range errs {
+				msgs = append(msgs, err.Error())
+			}
+
+			assert.Equal(t, test.errs, msgs)
+			assert.Equal(t, test.expected, stripSource(expr))
+		})
+	}
+}
+
+func pos(offset, line, column int) Position {
+	return Position{Offset: offset, Line: line, Column: column}
+}
+
+// stripSource clears source locations and literal spellings
+// so that trees can be compared by shape.
+func stripSource(expr Expression) Expression {
+	if expr == nil {
+		return nil
+	}
+
+	return Rewrite(expr, func(expr Expression) Expression {
+		switch expr := expr.(type) {
+		case Number:
+			expr.Literal, expr.Loc = "", Span{}
+			return expr
+		case Imaginary:
+			expr.Literal, expr.Loc = "", Span{}
+			return expr
+		case BinaryOp:
+			expr.Loc = Span{}
+			return expr
+		case UnaryOp:
+			expr.Loc = Span{}
+			return expr
+		case Parentheses:
+			expr.Loc = Span{}
+			return expr
+		case Conditional:
+			expr.Loc = Span{}
+			return expr
+		case Variable:
+			expr.Loc = Span{}
+			return expr
+		case Bad:
+			expr.Loc = Span{}
+			return expr
+		case Assignment:
+			expr.Loc = Span{}
+			return expr
+		case FunctionDef:
+			expr.Loc = Span{}
+			return expr
+		case Lambda:
+			expr.Loc = Span{}
+			return expr
+		case List:
+			expr.Loc = Span{}
+			return expr
+		case Index:
+			expr.Loc = Span{}
+			return expr
+		case Let:
+			expr.Loc = Span{}
+			return expr
+		case FunctionCall:
+			expr.Loc = Span{}
+			return expr
+		default:
+			return expr
+		}
+	})
+}
+
+type depthVisitor struct {
+	depth    int
+	maxDepth *int
+}
+
+func (v depthVisitor) Visit(expr Expression) Visitor {
+	if expr == nil {
+		return nil
+	}
+
+	if v.depth > *v.maxDepth {
+		*v.maxDepth = v.depth
+	}
+
+	return depthVisitor{depth: v.depth + 1, maxDepth: v.maxDepth}
+}
+
+func TestWalk(t *testing.T) {
+	prog, err := ParseProgram("f(a) = a * (b + sin(c)); let d = [e, g[0]] in d > 0 ? -h : h!")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var names []string
+	for _, stmt := range prog.Statements {
+		Inspect(stmt, func(expr Expression) bool {
+			switch expr := expr.(type) {
+			case Variable:
+				names = append(names, expr.Name)
+			case FunctionCall:
+				names = append(names, expr.Name+"()")
+			case Lambda:
+				return false
+			}
+
+			return true
+		})
+	}
+
+	assert.Equal(t, []string{"a", "b", "sin()", "c", "e", "g", "d", "h", "h"}, names)
+
+	// Inspect stops descending where f returns false, and calls f(nil) after the children
+	var visits []string
+	Inspect(prog.Statements[0], func(expr Expression) bool {
+		if expr == nil {
+			visits = append(visits, "end")
+			return false
+		}
+
+		visits = append(visits, expr.String())
+		_, isParens := expr.(Parentheses)
+		return !isParens
+	})
+	assert.Equal(t, []string{"f(a) = a * (b + sin(c))", "a * (b + sin(c))", "a", "end", "(b + sin(c))", "end", "end"}, visits)
+
+	maxDepth := 0
+	Walk(depthVisitor{maxDepth: &maxDepth}, prog.Statements[1])
+	assert.Equal(t, 4, maxDepth) // let, d = ..., [...], g[0], g
+
+	expr, err := Parse("(x + 1) * f(x, (y))")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// rename x and drop parens
+	rewritten := Rewrite(expr, func(expr Expression) Expression {
+		switch expr := expr.(type) {
+		case Variable:
+			if expr.Name == "x" {
+				expr.Name = "z"
+			}
+
+			return expr
+		case Parentheses:
+			return expr.Expr
+		}
+
+		return expr
+	})
+	assert.Equal(t, "(z + 1) * f(z, y)", rewritten.String())
+	assert.Equal(t, "(x + 1) * f(x, (y))", expr.String())
+	assert.Equal(t, expr.Span(), rewritten.Span())
+
+	// children are rewritten before their parents
+	var order []string
+	Rewrite(expr, func(expr Expression) Expression {
+		order = append(order, expr.String())
+		return expr
+	})
+	assert.Equal(t, []string{"x", "1", "x + 1", "(x + 1)", "x", "y", "(y)", "f(x, (y))", "(x + 1) * f(x, (y))"}, order)
+}
+
+func TestParseProgram(t *testing.T) {
+	x, a := Variable{Name: "x"}, Variable{Name: "a"}
 
-			assert.Equal(t, test.expected, expr)
+	tests := []struct {
+		name     string
+		input    string
+		expected []Expression
+		err      string
+	}{
+		{
+			name:  "semicolons",
+			input: "x = 3; f(a) = a^2 + x; f(x)",
+			expected: []Expression{
+				Assignment{Name: "x", Value: Number{Value: 3}},
+				FunctionDef{Name: "f", Params: []string{"a"}, Body: BinaryOp{
+					Op:    "+",
+					Left:  BinaryOp{Op: "^", Left: a, Right: Number{Value: 2}},
+					Right: x,
+				}},
+				FunctionCall{Name: "f", Args: []Expression{x}},
+			},
+		},
+		{
+			name:  "newlines",
+			input: "\nx = 3\n\n-x;;\n",
+			expected: []Expression{
+				Assignment{Name: "x", Value: Number{Value: 3}},
+				UnaryOp{Op: "-", Expr: x},
+			},
+		},
+		{
+			name:  "multiline-expression",
+			input: "x = 1 +\n  2\nf(x,\n  (x\n  - 1))",
+			expected: []Expression{
+				Assignment{Name: "x", Value: BinaryOp{Op: "+", Left: Number{Value: 1}, Right: Number{Value: 2}}},
+				FunctionCall{Name: "f", Args: []Expression{
+					x,
+					Parentheses{Expr: BinaryOp{Op: "-", Left: x, Right: Number{Value: 1}}},
+				}},
+			},
+		},
+		{
+			name:  "equality",
+			input: "x = a == 2",
+			expected: []Expression{
+				Assignment{Name: "x", Value: BinaryOp{Op: "==", Left: a, Right: Number{Value: 2}}},
+			},
+		},
+		{
+			name:     "empty",
+			input:    " ; \n",
+			expected: nil,
+		},
+		{
+			name:  "missing-separator",
+			input: "x = 1 y = 2",
+			err:   "1:7: unexpected identifier y",
+		},
+		{
+			name:  "bad-target",
+			input: "2 * x = 1",
+			err:   "1:7: cannot assign to 2 * x",
+		},
+		{
+			name:  "bad-parameter",
+			input: "f(a, 2) = a",
+			err:   "1:9: invalid parameter 2 of f",
+		},
+		{
+			name:  "duplicate-parameter",
+			input: "f(a, a) = a",
+			err:   "1:9: duplicate parameter a of f",
+		},
+		{
+			name:  "chained-assignment",
+			input: "x = a = 1",
+			err:   "1:7: unexpected '='",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			prog, err := ParseProgram(test.input)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			var stmts []Expression
+			for _, stmt := range prog.Statements {
+				stmts = append(stmts, stripSource(stmt))
+			}
+			assert.Equal(t, test.expected, stmts)
+		})
+	}
+
+	// a single expression has no statements
+	_, err := Parse("x = 1")
+	assert.EqualError(t, err, "1:3: unexpected '='")
+}
+
+func TestParseLambda(t *testing.T) {
+	x, a, b := Variable{Name: "x"}, Variable{Name: "a"}, Variable{Name: "b"}
+
+	tests := []struct {
+		input    string
+		expected Expression
+		str      string
+	}{
+		{
+			input:    "x -> x^2",
+			expected: Lambda{Params: []string{"x"}, Body: BinaryOp{Op: "^", Left: x, Right: Number{Value: 2}}},
+			str:      "x -> x^2",
+		},
+		{
+			input:    "(a, b) -> a*b + 1",
+			expected: Lambda{Params: []string{"a", "b"}, Body: BinaryOp{Op: "+", Left: BinaryOp{Op: "*", Left: a, Right: b}, Right: Number{Value: 1}}},
+			str:      "(a, b) -> a * b + 1",
+		},
+		{
+			input:    "() -> 1",
+			expected: Lambda{Body: Number{Value: 1}},
+			str:      "() -> 1",
+		},
+		{
+			input:    "(x) -> x",
+			expected: Lambda{Params: []string{"x"}, Body: x},
+			str:      "x -> x",
+		},
+		{
+			input:    "a -> b -> a - b",
+			expected: Lambda{Params: []string{"a"}, Body: Lambda{Params: []string{"b"}, Body: BinaryOp{Op: "-", Left: a, Right: b}}},
+			str:      "a -> b -> a - b",
+		},
+		{
+			input: "apply(x -> x, (a) - b)",
+			expected: FunctionCall{Name: "apply", Args: []Expression{
+				Lambda{Params: []string{"x"}, Body: x},
+				BinaryOp{Op: "-", Left: Parentheses{Expr: a}, Right: b},
+			}},
+			str: "apply(x -> x, (a) - b)",
+		},
+		{
+			input:    "2 * (x -> x)",
+			expected: BinaryOp{Op: "*", Left: Number{Value: 2}, Right: Parentheses{Expr: Lambda{Params: []string{"x"}, Body: x}}},
+			str:      "2 * (x -> x)",
+		},
+	}
+
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		if !assert.NoError(t, err, test.input) {
+			continue
+		}
+
+		assert.Equal(t, test.expected, stripSource(expr), test.input)
+		assert.Equal(t, test.str, expr.String(), test.input)
+	}
+
+	_, err := Parse("(a, a) -> a")
+	assert.EqualError(t, err, "1:8: duplicate parameter a of lambda")
+
+	_, err = Parse("(a, 1) -> a")
+	assert.EqualError(t, err, "1:3: expected ')', found ','")
+
+	// a lambda printed without its parens still binds loosely
+	assert.Equal(t, "2 * (x -> x)", BinaryOp{Op: "*", Left: Number{Value: 2}, Right: Lambda{Params: []string{"x"}, Body: x}}.String())
+}
+
+func TestParseLet(t *testing.T) {
+	d, k := Variable{Name: "d"}, Variable{Name: "k"}
+
+	expr, err := Parse("let d = 1, k = d + 1 in k * d")
+	assert.NoError(t, err)
+	assert.Equal(t, Let{
+		Bindings: []Expression{
+			Assignment{Name: "d", Value: Number{Value: 1}},
+			Assignment{Name: "k", Value: BinaryOp{Op: "+", Left: d, Right: Number{Value: 1}}},
+		},
+		Body: BinaryOp{Op: "*", Left: k, Right: d},
+	}, stripSource(expr))
+
+	expr, err = Parse("k * d where d = 1, k = 2")
+	assert.NoError(t, err)
+	assert.Equal(t, Let{
+		Bindings: []Expression{
+			Assignment{Name: "d", Value: Number{Value: 1}},
+			Assignment{Name: "k", Value: Number{Value: 2}},
+		},
+		Body:  BinaryOp{Op: "*", Left: k, Right: d},
+		Where: true,
+	}, stripSource(expr))
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "let d = 1 in d + 1", expected: "let d = 1 in d + 1"},
+		{input: "let sq(x) = x^2, f = x -> x in sq(f(2))", expected: "let sq(x) = x^2, f = x -> x in sq(f(2))"},
+		{input: "1 + (let d = 1 in d)", expected: "1 + (let d = 1 in d)"},
+		{input: "d + 1 where d = 1", expected: "d + 1 where d = 1"},
+		{input: "f((x where x = 1), y)", expected: "f((x where x = 1), y)"},
+		{input: "(d where d = 1) where e = 2", expected: "(d where d = 1) where e = 2"},
+		{input: "let x = 1 in y where y = x", expected: "let x = 1 in y where y = x"},
+		// only special where the grammar expects them
+		{input: "let + in * where", expected: "let + in * where"},
+		{input: "let(1)", expected: "let(1)"},
+	}
+
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		if assert.NoError(t, err, test.input) {
+			assert.Equal(t, test.expected, expr.String(), test.input)
+		}
+	}
+
+	expr, err = ParseWithOptions("let d = 2 in 3d where x = 1", Options{ImplicitMul: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "let d = 2 in 3 * d where x = 1", expr.String())
+
+	_, err = Parse("let d = 1 d")
+	assert.EqualError(t, err, "1:11: expected 'in', found identifier d")
+
+	_, err = Parse("x where 1 = 2")
+	assert.EqualError(t, err, "1:11: cannot assign to 1")
+
+	_, err = Parse("x where y")
+	assert.EqualError(t, err, "1:10: expected '=', found end of input")
+
+	_, errs := ParseAll("(x where y) + (let d = 1 d)")
+	assert.Len(t, errs, 2)
+}
+
+func TestParseVectors(t *testing.T) {
+	x, v := Variable{Name: "x"}, Variable{Name: "v"}
+
+	tests := []struct {
+		input    string
+		expected Expression
+		str      string
+	}{
+		{
+			input:    "[1, x]",
+			expected: List{Items: []Expression{Number{Value: 1}, x}},
+			str:      "[1, x]",
+		},
+		{
+			input:    "[]",
+			expected: List{},
+			str:      "[]",
+		},
+		{
+			input:    "-v[x + 1]^2",
+			expected: UnaryOp{Op: "-", Expr: BinaryOp{Op: "^", Left: Index{Expr: v, Index: BinaryOp{Op: "+", Left: x, Right: Number{Value: 1}}}, Right: Number{Value: 2}}},
+			str:      "-v[x + 1]^2",
+		},
+		{
+			input:    "[1, 2][0]",
+			expected: Index{Expr: List{Items: []Expression{Number{Value: 1}, Number{Value: 2}}}, Index: Number{Value: 0}},
+			str:      "[1, 2][0]",
+		},
+		{
+			input:    "(v + 1)[0]",
+			expected: Index{Expr: Parentheses{Expr: BinaryOp{Op: "+", Left: v, Right: Number{Value: 1}}}, Index: Number{Value: 0}},
+			str:      "(v + 1)[0]",
+		},
+	}
+
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		if !assert.NoError(t, err, test.input) {
+			continue
+		}
+
+		assert.Equal(t, test.expected, stripSource(expr), test.input)
+		assert.Equal(t, test.str, expr.String(), test.input)
+	}
+
+	_, err := Parse("[1, 2")
+	assert.EqualError(t, err, "1:6: expected ']', found end of input")
+
+	_, err = Parse("v[1")
+	assert.EqualError(t, err, "1:4: expected ']', found end of input")
+
+	prog, err := ParseProgram("v = [1,\n  2]\nv[0]")
+	assert.NoError(t, err)
+	assert.Len(t, prog.Statements, 2)
+}
+
+func TestParseComplex(t *testing.T) {
+	x := Variable{Name: "x"}
+
+	tests := []struct {
+		input    string
+		expected Expression
+		str      string
+	}{
+		{
+			input:    "1 + 2i",
+			expected: BinaryOp{Op: "+", Left: Number{Value: 1}, Right: Imaginary{Value: 2}},
+			str:      "1 + 2i",
+		},
+		{
+			input:    "-0.5i * x",
+			expected: BinaryOp{Op: "*", Left: UnaryOp{Op: "-", Expr: Imaginary{Value: 0.5}}, Right: x},
+			str:      "-0.5i * x",
+		},
+		{
+			input:    "1e3i^2",
+			expected: BinaryOp{Op: "^", Left: Imaginary{Value: 1000}, Right: Number{Value: 2}},
+			str:      "1e3i^2",
+		},
+	}
+
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		if !assert.NoError(t, err, test.input) {
+			continue
+		}
+
+		assert.Equal(t, test.expected, stripSource(expr), test.input)
+		assert.Equal(t, test.str, expr.String(), test.input)
+	}
+
+	assert.Equal(t, "-2i", BinaryOp{Op: "*", Left: Imaginary{Value: -2}, Right: x}.Left.String())
+	assert.Equal(t, "x^(-2i)", BinaryOp{Op: "^", Left: x, Right: Imaginary{Value: -2}}.String())
+
+	expr, err := ParseWithOptions("3i x", Options{ImplicitMul: true})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "3i * x", expr.String())
+	}
+
+	_, err = Parse("2 3i")
+	assert.EqualError(t, err, "1:3: unexpected number 3i")
+}
+
+func TestParseImplicitMul(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "2x", expected: "2 * x"},
+		{input: "3(x+1)", expected: "3 * (x + 1)"},
+		{input: "(a)(b)", expected: "(a) * (b)"},
+		{input: "2 sin(x)", expected: "2 * sin(x)"},
+		{input: "1/2x", expected: "1 / (2 * x)"},
+		{input: "2x^2", expected: "2 * x^2"},
+		{input: "-2x", expected: "-2 * x"},
+		{input: "2(x+1)(x-1)", expected: "2 * (x + 1) * (x - 1)"},
+		{input: "f(x)y", expected: "f(x) * y"},
+		{input: "2 3", expected: "1:3: unexpected number 3"},
+		{input: "x y", expected: "1:3: unexpected identifier y"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			expr, err := ParseWithOptions(test.input, Options{ImplicitMul: true})
+			if err != nil {
+				assert.EqualError(t, err, test.expected)
+				return
+			}
+
+			assert.Equal(t, test.expected, expr.String())
+
+			// the explicit form means the same
+			explicit, err := Parse(expr.String())
+			if assert.NoError(t, err) {
+				assert.Equal(t, stripParens(stripSource(expr)), stripParens(stripSource(explicit)))
+			}
 		})
 	}
+
+	_, err := Parse("2x")
+	assert.EqualError(t, err, "1:2: unexpected identifier x")
+}
+
+func TestExpressionString(t *testing.T) {
+	x, y := Variable{Name: "x"}, Variable{Name: "y"}
+
+	tests := []struct {
+		expr     Expression
+		expected string
+	}{
+		{
+			expr:     BinaryOp{Op: "-", Left: x, Right: BinaryOp{Op: "-", Left: y, Right: Number{Value: 1}}},
+			expected: "x - (y - 1)",
+		},
+		{
+			expr:     BinaryOp{Op: "-", Left: BinaryOp{Op: "-", Left: x, Right: y}, Right: Number{Value: 1}},
+			expected: "x - y - 1",
+		},
+		{
+			expr:     BinaryOp{Op: "*", Left: BinaryOp{Op: "+", Left: x, Right: y}, Right: x},
+			expected: "(x + y) * x",
+		},
+		{
+			expr:     BinaryOp{Op: "^", Left: BinaryOp{Op: "^", Left: x, Right: y}, Right: Number{Value: 2}},
+			expected: "(x^y)^2",
+		},
+		{
+			expr:     BinaryOp{Op: "^", Left: Number{Value: -2}, Right: UnaryOp{Op: "-", Expr: x}},
+			expected: "(-2)^-x",
+		},
+		{
+			expr:     UnaryOp{Op: "!", IsPostfix: true, Expr: BinaryOp{Op: "+", Left: x, Right: y}},
+			expected: "(x + y)!",
+		},
+		{
+			expr:     UnaryOp{Op: "-", Expr: BinaryOp{Op: "*", Left: x, Right: y}},
+			expected: "-(x * y)",
+		},
+		{
+			expr: Conditional{
+				Cond: Conditional{Cond: x, Then: y, Else: x},
+				Then: y,
+				Else: Conditional{Cond: x, Then: y, Else: x},
+			},
+			expected: "(x ? y : x) ? y : x ? y : x",
+		},
+		{
+			expr: FunctionCall{Name: "f", Args: []Expression{
+				Let{Bindings: []Expression{Assignment{Name: "x", Value: y}}, Body: x, Where: true},
+				y,
+			}},
+			expected: "f((x where x = y), y)",
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.expr.String())
+	}
+}
+
+// stripParens drops Parentheses nodes, which only record how the input was spelled.
+func stripParens(expr Expression) Expression {
+	switch expr := expr.(type) {
+	case Parentheses:
+		return stripParens(expr.Expr)
+	case BinaryOp:
+		expr.Left, expr.Right = stripParens(expr.Left), stripParens(expr.Right)
+		return expr
+	case UnaryOp:
+		expr.Expr = stripParens(expr.Expr)
+		return expr
+	case FunctionCall:
+		for i, arg := range expr.Args {
+			expr.Args[i] = stripParens(arg)
+		}
+		return expr
+	default:
+		return expr
+	}
 }
@@ -0,0 +1,45 @@
+package calculon
+
+import "strings"
+
+// Program is a sequence of statements separated by newlines or semicolons:
+//
+//	x = 3; y = x * 2
+//	f(a) = a^2 + y
+//	f(y)
+//
+// Statements are assignments, function definitions and plain expressions.
+type Program struct {
+	Statements []Expression
+}
+
+// Run executes the statements in order and returns the value of the last one.
+// Assignments and definitions go to ctx, so a program can extend a context
+// for later runs, or work in a NewScope to leave the context intact.
+func (prog *Program) Run(ctx MutableContext) (float64, error) {
+	return number(prog.RunValue(ctx))
+}
+
+// RunValue is like Run, but the result may be of any kind, such as a Vector.
+func (prog *Program) RunValue(ctx MutableContext) (Value, error) {
+	var result Value = Float(0)
+	for _, stmt := range prog.Statements {
+		val, err := stmt.EvalValue(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		result = val
+	}
+
+	return result, nil
+}
+
+func (prog *Program) String() string {
+	stmts := make([]string, 0, len(prog.Statements))
+	for _, stmt := range prog.Statements {
+		stmts = append(stmts, stmt.String())
+	}
+
+	return strings.Join(stmts, "\n")
+}
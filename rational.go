@@ -0,0 +1,216 @@
+package calculon
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+const KindRational Kind = "rational"
+
+// maxExactExponent bounds the integer powers computed exactly,
+// larger ones would take too long and are computed with floats.
+const maxExactExponent = 1 << 16
+
+// maxExactFactorial bounds the factorials computed exactly.
+const maxExactFactorial = 10000
+
+// Rational is an exact fraction, such as -3/2. Operators that cannot give
+// an exact result, such as ^ with a fractional exponent, treat it as a float.
+type Rational struct {
+	*big.Rat
+}
+
+func (r Rational) Kind() Kind { return KindRational }
+
+func (r Rational) String() string { return r.RatString() }
+
+func (r Rational) float() (float64, bool) {
+	f, _ := r.Float64()
+	return f, true
+}
+
+// rationalOps are the operators with an exact meaning for rational numbers.
+// The others, and operators mixing rationals with floats, give floats.
+var rationalOps = map[string]func(l, r *big.Rat) (Value, error){
+	"+":  func(l, r *big.Rat) (Value, error) { return Rational{new(big.Rat).Add(l, r)}, nil },
+	"-":  func(l, r *big.Rat) (Value, error) { return Rational{new(big.Rat).Sub(l, r)}, nil },
+	"*":  func(l, r *big.Rat) (Value, error) { return Rational{new(big.Rat).Mul(l, r)}, nil },
+	"==": func(l, r *big.Rat) (Value, error) { return ratTruth(l.Cmp(r) == 0), nil },
+	"!=": func(l, r *big.Rat) (Value, error) { return ratTruth(l.Cmp(r) != 0), nil },
+	"<":  func(l, r *big.Rat) (Value, error) { return ratTruth(l.Cmp(r) < 0), nil },
+	"<=": func(l, r *big.Rat) (Value, error) { return ratTruth(l.Cmp(r) <= 0), nil },
+	">":  func(l, r *big.Rat) (Value, error) { return ratTruth(l.Cmp(r) > 0), nil },
+	">=": func(l, r *big.Rat) (Value, error) { return ratTruth(l.Cmp(r) >= 0), nil },
+	"&&": func(l, r *big.Rat) (Value, error) { return ratTruth(l.Sign() != 0 && r.Sign() != 0), nil },
+	"||": func(l, r *big.Rat) (Value, error) { return ratTruth(l.Sign() != 0 || r.Sign() != 0), nil },
+	"xor": func(l, r *big.Rat) (Value, error) {
+		return ratTruth((l.Sign() != 0) != (r.Sign() != 0)), nil
+	},
+	"/": func(l, r *big.Rat) (Value, error) {
+		if r.Sign() == 0 {
+			return nil, fmt.Errorf("divide by zero")
+		}
+
+		return Rational{new(big.Rat).Quo(l, r)}, nil
+	},
+	// remainder of the division truncated towards zero, like math.Mod
+	"%": func(l, r *big.Rat) (Value, error) {
+		if r.Sign() == 0 {
+			return Float(math.NaN()), nil
+		}
+
+		q := new(big.Rat).Quo(l, r)
+		trunc := new(big.Int).Quo(q.Num(), q.Denom())
+		q.SetInt(trunc)
+
+		return Rational{q.Sub(l, q.Mul(q, r))}, nil
+	},
+	"^": func(l, r *big.Rat) (Value, error) {
+		exp := r.Num().Int64()
+		if !r.IsInt() || !r.Num().IsInt64() || exp > maxExactExponent || exp < -maxExactExponent {
+			lf, _ := l.Float64()
+			rf, _ := r.Float64()
+			return Float(math.Pow(lf, rf)), nil
+		}
+
+		if exp < 0 {
+			if l.Sign() == 0 {
+				return Float(math.Inf(1)), nil
+			}
+
+			l, exp = new(big.Rat).Inv(l), -exp
+		}
+
+		num := new(big.Int).Exp(l.Num(), big.NewInt(exp), nil)
+		denom := new(big.Int).Exp(l.Denom(), big.NewInt(exp), nil)
+
+		return Rational{new(big.Rat).SetFrac(num, denom)}, nil
+	},
+}
+
+func init() {
+	for op, fn := range rationalOps {
+		fn := fn
+		infixValueOps[infixKey{op: op, left: KindRational, right: KindRational}] = func(l, r Value) (Value, error) {
+			return fn(l.(Rational).Rat, r.(Rational).Rat)
+		}
+	}
+
+	unaryValueOps[unaryKey{op: "-", kind: KindRational}] = func(x Value) (Value, error) {
+		return Rational{new(big.Rat).Neg(x.(Rational).Rat)}, nil
+	}
+	unaryValueOps[unaryKey{op: "+", kind: KindRational}] = func(x Value) (Value, error) { return x, nil }
+	unaryValueOps[unaryKey{op: "!", kind: KindRational}] = func(x Value) (Value, error) {
+		return ratTruth(x.(Rational).Sign() == 0), nil
+	}
+	unaryValueOps[unaryKey{op: "%", postfix: true, kind: KindRational}] = func(x Value) (Value, error) {
+		return Rational{new(big.Rat).Quo(x.(Rational).Rat, big.NewRat(100, 1))}, nil
+	}
+	unaryValueOps[unaryKey{op: "!", postfix: true, kind: KindRational}] = ratFactorial
+}
+
+// ratFactorial is exact for integers, other numbers go through factorial.
+func ratFactorial(x Value) (Value, error) {
+	r := x.(Rational).Rat
+	if !r.IsInt() || r.Sign() < 0 || r.Cmp(big.NewRat(maxExactFactorial, 1)) > 0 {
+		f, _ := r.Float64()
+		result, err := factorial(f)
+		return Float(result), err
+	}
+
+	n := r.Num().Int64()
+	if n == 0 {
+		return Rational{big.NewRat(1, 1)}, nil
+	}
+
+	return Rational{new(big.Rat).SetInt(new(big.Int).MulRange(1, n))}, nil
+}
+
+// ratTruth is the rational counterpart of truth.
+func ratTruth(b bool) Rational {
+	return Rational{big.NewRat(int64(truth(b)), 1)}
+}
+
+// parseRational converts a number literal to the fraction it spells exactly.
+// Numbers without a literal are converted through their shortest decimal form,
+// so that 0.1 is 1/10 rather than the fraction closest to the float.
+func parseRational(literal string, value float64) (*big.Rat, bool) {
+	if literal == "" {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return nil, false
+		}
+
+		literal = formatFloat(value)
+	}
+
+	if len(literal) > 2 && literal[0] == '0' && strings.ContainsRune("xXoObB", rune(literal[1])) {
+		n, ok := new(big.Int).SetString(literal, 0)
+		if !ok {
+			return nil, false
+		}
+
+		return new(big.Rat).SetInt(n), true
+	}
+
+	return new(big.Rat).SetString(strings.ReplaceAll(literal, "_", ""))
+}
+
+// rationalModeFuncs replace the builtins of the same name in RationalMode.
+var rationalModeFuncs = map[string]LazyValueFunction{
+	"abs": eagerValue(func(args []Value) (Value, error) {
+		if len(args) == 1 {
+			if r, ok := args[0].(Rational); ok {
+				return Rational{new(big.Rat).Abs(r.Rat)}, nil
+			}
+		}
+
+		return abs(args)
+	}),
+	// len counts exactly
+	"len": func(ctx EvalContext, args []Expression) (Value, error) {
+		n, err := builtinLazyFuncs["len"](ctx, args)
+		if err != nil {
+			return nil, err
+		}
+
+		return Rational{big.NewRat(int64(n), 1)}, nil
+	},
+}
+
+// RationalMode evaluates the numbers of ctx as exact fractions, so that
+// 0.1 + 0.2 is 3/10 and 2*(3-4)+2/4 is -3/2. Whatever has no exact result,
+// such as sin(1) or 2^0.5, evaluates to a float, and so does arithmetic
+// mixing such floats with rationals.
+func RationalMode(ctx EvalContext) EvalContext {
+	return &modeContext{
+		parent: ctx,
+		number: func(literal string, value float64) (Value, error) {
+			if r, ok := parseRational(literal, value); ok {
+				return Rational{r}, nil
+			}
+
+			return Float(value), nil
+		},
+		funcs: rationalModeFuncs,
+	}
+}
+
+// EvalRational evaluates expr in RationalMode(ctx). It fails
+// if the result is not exact, see RationalMode.
+func EvalRational(expr Expression, ctx EvalContext) (*big.Rat, error) {
+	val, err := expr.EvalValue(RationalMode(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	switch val := val.(type) {
+	case Rational:
+		return val.Rat, nil
+	case Float:
+		return nil, fmt.Errorf("inexact result: %s", val)
+	default:
+		return nil, fmt.Errorf("%s used as a rational number: %s", val.Kind(), val)
+	}
+}
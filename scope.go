@@ -0,0 +1,233 @@
+package calculon
+
+var (
+	_ MutableContext   = (*Scope)(nil)
+	_ ValueContext     = (*Scope)(nil)
+	_ NamespaceContext = (*Scope)(nil)
+	_ LazyContext      = (*Scope)(nil)
+	_ NumberContext    = (*Scope)(nil)
+	_ ArityContext     = (*Scope)(nil)
+)
+
+// Scope layers its own variables and functions over a parent context.
+// Names defined in the scope shadow the parent ones, and the parent is never modified.
+type Scope struct {
+	parent     EvalContext
+	vars       map[string]float64
+	values     map[string]Value
+	funcs      map[string]Function
+	lazyFuncs  map[string]LazyFunction
+	valueFuncs map[string]LazyValueFunction
+	arities    map[string]Arity
+
+	// number of user function calls the scope is nested in
+	depth int
+	// limit of depth set with SetMaxCallDepth, 0 for that of the parent
+	maxCallDepth int
+}
+
+func NewScope(parent EvalContext) *Scope {
+	if parent == nil {
+		parent = EmptyContext{}
+	}
+	return &Scope{
+		parent:     parent,
+		vars:       map[string]float64{},
+		values:     map[string]Value{},
+		funcs:      map[string]Function{},
+		lazyFuncs:  map[string]LazyFunction{},
+		valueFuncs: map[string]LazyValueFunction{},
+		arities:    map[string]Arity{},
+		depth:      callDepth(parent),
+	}
+}
+
+// callDepth returns how many user function calls ctx is nested in.
+func callDepth(ctx EvalContext) int {
+	if s, ok := ctx.(*Scope); ok {
+		return s.depth
+	}
+
+	return 0
+}
+
+// SetMaxCallDepth limits how deep calls of user functions may nest when
+// evaluating in the scope, in place of the limit of the parent.
+func (s *Scope) SetMaxCallDepth(depth int) {
+	s.maxCallDepth = depth
+}
+
+// maxCallDepth returns the limit of call depth in ctx, see SetMaxCallDepth.
+func maxCallDepth(ctx EvalContext) int {
+	for ctx != nil {
+		switch c := ctx.(type) {
+		case *Scope:
+			if c.maxCallDepth > 0 {
+				return c.maxCallDepth
+			}
+		case *Context:
+			if c.maxCallDepth > 0 {
+				return c.maxCallDepth
+			}
+		}
+
+		ctx, _ = parentOf(ctx)
+	}
+
+	return MaxCallDepth
+}
+
+func (s *Scope) SetVar(name string, value float64) {
+	delete(s.values, name)
+	s.vars[name] = value
+}
+
+func (s *Scope) SetValue(name string, value Value) {
+	if f, ok := value.(Float); ok {
+		s.SetVar(name, float64(f))
+		return
+	}
+
+	delete(s.vars, name)
+	s.values[name] = value
+}
+
+func (s *Scope) SetFunc(name string, fn Function) {
+	s.undefineFunc(name)
+	s.funcs[name] = fn
+}
+
+func (s *Scope) SetLazyFunc(name string, fn LazyFunction) {
+	s.undefineFunc(name)
+	s.lazyFuncs[name] = fn
+}
+
+func (s *Scope) SetValueFunc(name string, fn ValueFunction) {
+	s.SetLazyValueFunc(name, eagerValue(fn))
+}
+
+func (s *Scope) SetLazyValueFunc(name string, fn LazyValueFunction) {
+	s.undefineFunc(name)
+	s.valueFuncs[name] = fn
+}
+
+// SetArity declares how many arguments the function name takes, see Check.
+// Defining the function anew forgets it.
+func (s *Scope) SetArity(name string, arity Arity) {
+	s.arities[name] = arity
+}
+
+func (s *Scope) undefineFunc(name string) {
+	delete(s.arities, name)
+	delete(s.funcs, name)
+	delete(s.lazyFuncs, name)
+	delete(s.valueFuncs, name)
+}
+
+// definesFunc reports whether the scope itself has a function of any kind called name.
+func (s *Scope) definesFunc(name string) bool {
+	_, fn := s.funcs[name]
+	_, lazy := s.lazyFuncs[name]
+	_, value := s.valueFuncs[name]
+
+	return fn || lazy || value
+}
+
+func (s *Scope) LookupVar(name string) (float64, bool) {
+	val, found := s.vars[name]
+	if found {
+		return val, true
+	}
+
+	if _, found := s.values[name]; found {
+		return 0, false
+	}
+
+	return s.parent.LookupVar(name)
+}
+
+func (s *Scope) LookupValue(name string) (Value, bool) {
+	if val, found := s.values[name]; found {
+		return val, true
+	}
+
+	if val, found := s.vars[name]; found {
+		return Float(val), true
+	}
+
+	if parent, ok := s.parent.(ValueContext); ok {
+		return parent.LookupValue(name)
+	}
+
+	val, found := s.parent.LookupVar(name)
+	return Float(val), found
+}
+
+func (s *Scope) LookupFunc(name string) (Function, bool) {
+	if fn, found := s.funcs[name]; found {
+		return fn, true
+	}
+
+	if s.definesFunc(name) {
+		return nil, false
+	}
+
+	return s.parent.LookupFunc(name)
+}
+
+func (s *Scope) LookupLazyFunc(name string) (LazyFunction, bool) {
+	if fn, found := s.lazyFuncs[name]; found {
+		return fn, true
+	}
+
+	if s.definesFunc(name) {
+		return nil, false
+	}
+
+	if parent, ok := s.parent.(LazyContext); ok {
+		return parent.LookupLazyFunc(name)
+	}
+
+	return nil, false
+}
+
+func (s *Scope) LookupValueFunc(name string) (LazyValueFunction, bool) {
+	if fn, found := s.valueFuncs[name]; found {
+		return fn, true
+	}
+
+	if s.definesFunc(name) {
+		return nil, false
+	}
+
+	if parent, ok := s.parent.(ValueContext); ok {
+		return parent.LookupValueFunc(name)
+	}
+
+	return nil, false
+}
+
+func (s *Scope) LookupArity(name string) (Arity, bool) {
+	if arity, found := s.arities[name]; found {
+		return arity, true
+	}
+
+	if s.definesFunc(name) {
+		return Arity{}, false
+	}
+
+	return lookupArity(s.parent, name)
+}
+
+// NumberValue converts numbers the way the parent context does.
+func (s *Scope) NumberValue(literal string, value float64) (Value, error) {
+	return numberValue(s.parent, literal, value)
+}
+
+func (s *Scope) LookupNamespace(name string) (EvalContext, bool) {
+	if parent, ok := s.parent.(NamespaceContext); ok {
+		return parent.LookupNamespace(name)
+	}
+
+	return nil, false
+}
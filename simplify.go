@@ -0,0 +1,510 @@
+package calculon
+
+import (
+	"math"
+)
+
+// SimplifyOptions select the rewrites of Simplify that are not always exact.
+type SimplifyOptions struct {
+	// FoldFuncs folds calls of its functions whose arguments are constants,
+	// by evaluating them in it. Functions are not folded if it is nil.
+	FoldFuncs EvalContext
+	// Impure names functions that give different results for the same arguments,
+	// such as rand(), or have effects. Their calls are neither folded nor
+	// taken as like terms.
+	Impure []string
+	// IgnoreNaN allows rewrites that only hold for finite numbers, and drop
+	// the errors evaluating x may give: x * 0 and 0 / x become 0, x - x becomes 0,
+	// x / x and x^0 become 1.
+	IgnoreNaN bool
+	// CollectTerms collects like terms: 2*x + y - x is x + y, x * 3 * x is 3 * x^2.
+	// Sums and products are reordered, with constants first in products and last
+	// in sums, so results may be rounded differently.
+	CollectTerms bool
+}
+
+// Simplify returns a simpler expression evaluating to the same numbers as expr,
+// so that it can be evaluated faster, or read more easily: 2 * (3 + 4) * x is 14 * x.
+//
+// Operators of constants are folded, as are conditionals with constant conditions,
+// parentheses are dropped, since trees print with the parens they need, and the
+// identities x * 1, x / 1, x + 0, x - 0, x^1, --x and +x are applied.
+// They hold for all numbers except for the sign of zero. opts allow other rewrites.
+//
+// Constants are folded with float64 numbers, so the result is meant for
+// evaluation with numbers rather than in modes such as RationalMode.
+func Simplify(expr Expression, opts SimplifyOptions) Expression {
+	return simplifier{opts: opts}.simplify(expr)
+}
+
+// simplifier rewrites expressions, its methods for operators build the nodes
+// of simplified expressions from simplified operands.
+type simplifier struct {
+	opts SimplifyOptions
+}
+
+func (s simplifier) simplify(expr Expression) Expression {
+	switch expr := expr.(type) {
+	case Parentheses:
+		return s.simplify(expr.Expr)
+	case BinaryOp:
+		return located(s.binaryOp(expr.Op, s.simplify(expr.Left), s.simplify(expr.Right)), expr.Loc)
+	case UnaryOp:
+		return located(s.unaryOp(UnaryOp{Op: expr.Op, Expr: s.simplify(expr.Expr), IsPostfix: expr.IsPostfix}), expr.Loc)
+	case Conditional:
+		cond := s.simplify(expr.Cond)
+		if c, ok := numberOf(cond); ok {
+			if c != 0 {
+				return s.simplify(expr.Then)
+			}
+
+			return s.simplify(expr.Else)
+		}
+
+		return Conditional{Cond: cond, Then: s.simplify(expr.Then), Else: s.simplify(expr.Else), Loc: expr.Loc}
+	case FunctionCall:
+		return s.call(FunctionCall{Name: expr.Name, Args: s.simplifyAll(expr.Args), Loc: expr.Loc})
+	case List:
+		return List{Items: s.simplifyAll(expr.Items), Loc: expr.Loc}
+	case Index:
+		return Index{Expr: s.simplify(expr.Expr), Index: s.simplify(expr.Index), Loc: expr.Loc}
+	case Assignment:
+		return Assignment{Name: expr.Name, Value: s.simplify(expr.Value), Loc: expr.Loc}
+	case FunctionDef:
+		return FunctionDef{Name: expr.Name, Params: expr.Params, Body: s.simplify(expr.Body), Loc: expr.Loc}
+	case Lambda:
+		return Lambda{Params: expr.Params, Body: s.simplify(expr.Body), Loc: expr.Loc}
+	case Let:
+		return Let{Bindings: s.simplifyAll(expr.Bindings), Body: s.simplify(expr.Body), Where: expr.Where, Loc: expr.Loc}
+	default:
+		return expr
+	}
+}
+
+func (s simplifier) simplifyAll(exprs []Expression) []Expression {
+	simplified := make([]Expression, len(exprs))
+	for i, expr := range exprs {
+		simplified[i] = s.simplify(expr)
+	}
+
+	return simplified
+}
+
+// located gives a node built by the simplifier the span of the one it replaces.
+func located(expr Expression, loc Span) Expression {
+	switch e := expr.(type) {
+	case BinaryOp:
+		if e.Loc == (Span{}) {
+			e.Loc = loc
+		}
+
+		return e
+	case UnaryOp:
+		if e.Loc == (Span{}) {
+			e.Loc = loc
+		}
+
+		return e
+	case Number:
+		if e.Loc == (Span{}) {
+			e.Loc = loc
+		}
+
+		return e
+	default:
+		return expr
+	}
+}
+
+func (s simplifier) binaryOp(op string, l, r Expression) Expression {
+	switch op {
+	case "+":
+		return s.collectTerms(s.add(l, r))
+	case "-":
+		return s.collectTerms(s.sub(l, r))
+	case "*":
+		return s.collectFactors(s.mul(l, r))
+	case "/":
+		return s.div(l, r)
+	case "^":
+		return s.pow(l, r)
+	default:
+		return binary(op, l, r)
+	}
+}
+
+func (s simplifier) unaryOp(expr UnaryOp) Expression {
+	if expr.IsPostfix {
+		if x, ok := numberOf(expr.Expr); ok {
+			if folded, ok := foldValue(evalUnary(expr.Op, true, Float(x))); ok {
+				return folded
+			}
+		}
+
+		return expr
+	}
+
+	switch expr.Op {
+	case "-":
+		return s.neg(expr.Expr)
+	case "+":
+		return expr.Expr
+	}
+
+	if x, ok := numberOf(expr.Expr); ok {
+		if folded, ok := foldValue(evalUnary(expr.Op, false, Float(x))); ok {
+			return folded
+		}
+	}
+
+	return expr
+}
+
+// call folds a call of a pure function with constant arguments.
+func (s simplifier) call(expr FunctionCall) Expression {
+	if s.opts.FoldFuncs == nil {
+		return expr
+	}
+
+	if !s.pure(expr) {
+		return expr
+	}
+
+	for _, arg := range expr.Args {
+		if _, ok := numberOf(arg); !ok {
+			return expr
+		}
+	}
+
+	if folded, ok := foldValue(expr.EvalValue(s.opts.FoldFuncs)); ok {
+		return located(folded, expr.Loc)
+	}
+
+	return expr
+}
+
+func num(x float64) Number {
+	return Number{Value: x}
+}
+
+func unparen(expr Expression) Expression {
+	for {
+		paren, ok := expr.(Parentheses)
+		if !ok {
+			return expr
+		}
+
+		expr = paren.Expr
+	}
+}
+
+// numberOf returns the value of a number, or of a negated one.
+func numberOf(expr Expression) (float64, bool) {
+	switch e := unparen(expr).(type) {
+	case Number:
+		return e.Value, true
+	case UnaryOp:
+		if e.Op == "-" && !e.IsPostfix {
+			if x, ok := numberOf(e.Expr); ok {
+				return 0 - x, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+func isNumber(expr Expression, x float64) bool {
+	n, ok := numberOf(expr)
+	return ok && n == x
+}
+
+func isZero(expr Expression) bool { return isNumber(expr, 0) }
+
+// same reports whether two expressions are the same, as far as their text tells,
+// and evaluate to the same each time.
+func (s simplifier) same(l, r Expression) bool {
+	return unparen(l).String() == unparen(r).String() && s.pure(l)
+}
+
+// pure reports whether expr gives the same result every time, which it does
+// unless it calls impure functions or defines names.
+func (s simplifier) pure(expr Expression) bool {
+	pure := true
+	Inspect(expr, func(e Expression) bool {
+		switch e := e.(type) {
+		case Assignment, FunctionDef, Lambda, Let, Bad:
+			pure = false
+		case FunctionCall:
+			for _, name := range s.opts.Impure {
+				if name == e.Name {
+					pure = false
+				}
+			}
+		}
+
+		return pure
+	})
+
+	return pure
+}
+
+// foldValue makes a number of a finite result, numbers cannot spell the others.
+func foldValue(val Value, err error) (Expression, bool) {
+	f, ok := val.(Float)
+	if err != nil || !ok || math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+		return nil, false
+	}
+
+	return num(float64(f)), true
+}
+
+// binary builds a binary operator, folding it if both operands are numbers.
+func binary(op string, l, r Expression) Expression {
+	ln, lok := numberOf(l)
+	rn, rok := numberOf(r)
+	if lok && rok {
+		if folded, ok := foldValue(evalInfix(op, Float(ln), Float(rn))); ok {
+			return folded
+		}
+	}
+
+	return BinaryOp{Op: op, Left: l, Right: r}
+}
+
+func (s simplifier) add(l, r Expression) Expression {
+	switch {
+	case isZero(l):
+		return r
+	case isZero(r):
+		return l
+	}
+
+	// u + -v is u - v
+	if minus, ok := unparen(r).(UnaryOp); ok && minus.Op == "-" && !minus.IsPostfix {
+		return s.sub(l, minus.Expr)
+	}
+
+	return binary("+", l, r)
+}
+
+func (s simplifier) sub(l, r Expression) Expression {
+	switch {
+	case isZero(r):
+		return l
+	case isZero(l):
+		return s.neg(r)
+	case s.opts.IgnoreNaN && s.same(l, r):
+		return num(0)
+	}
+
+	// u - -v is u + v
+	if minus, ok := unparen(r).(UnaryOp); ok && minus.Op == "-" && !minus.IsPostfix {
+		return s.add(l, minus.Expr)
+	}
+
+	return binary("-", l, r)
+}
+
+func (s simplifier) mul(l, r Expression) Expression {
+	switch {
+	case s.opts.IgnoreNaN && (isZero(l) || isZero(r)):
+		return num(0)
+	case isNumber(l, 1):
+		return r
+	case isNumber(r, 1):
+		return l
+	case isNumber(l, -1):
+		return s.neg(r)
+	case isNumber(r, -1):
+		return s.neg(l)
+	}
+
+	return binary("*", l, r)
+}
+
+func (s simplifier) div(l, r Expression) Expression {
+	switch {
+	case isNumber(r, 1):
+		return l
+	case s.opts.IgnoreNaN && isZero(l) && !isZero(r):
+		return num(0)
+	case s.opts.IgnoreNaN && s.same(l, r) && !isZero(r):
+		return num(1)
+	}
+
+	return binary("/", l, r)
+}
+
+func (s simplifier) pow(l, r Expression) Expression {
+	switch {
+	case s.opts.IgnoreNaN && isZero(r):
+		return num(1)
+	case isNumber(r, 1):
+		return l
+	}
+
+	return binary("^", l, r)
+}
+
+func (s simplifier) neg(expr Expression) Expression {
+	switch e := unparen(expr).(type) {
+	case Number:
+		return num(0 - e.Value)
+	case UnaryOp:
+		if e.Op == "-" && !e.IsPostfix {
+			return e.Expr
+		}
+	}
+
+	return UnaryOp{Op: "-", Expr: expr}
+}
+
+// term is a constant multiple of an expression, or a constant if expr is nil.
+type term struct {
+	coef float64
+	expr Expression
+}
+
+// terms splits a sum into its terms, multiplying them by sign.
+func terms(expr Expression, sign float64, acc []term) []term {
+	if x, ok := numberOf(expr); ok {
+		return append(acc, term{coef: sign * x})
+	}
+
+	switch e := unparen(expr).(type) {
+	case BinaryOp:
+		switch e.Op {
+		case "+":
+			return terms(e.Right, sign, terms(e.Left, sign, acc))
+		case "-":
+			return terms(e.Right, -sign, terms(e.Left, sign, acc))
+		case "*":
+			if c, ok := numberOf(e.Left); ok {
+				return append(acc, term{coef: sign * c, expr: e.Right})
+			}
+
+			if c, ok := numberOf(e.Right); ok {
+				return append(acc, term{coef: sign * c, expr: e.Left})
+			}
+		}
+	case UnaryOp:
+		if e.Op == "-" && !e.IsPostfix {
+			return terms(e.Expr, -sign, acc)
+		}
+	}
+
+	return append(acc, term{coef: sign, expr: expr})
+}
+
+// collectTerms adds up the multiples of the same expressions in a sum.
+func (s simplifier) collectTerms(expr Expression) Expression {
+	if !s.opts.CollectTerms {
+		return expr
+	}
+
+	var (
+		collected []term
+		constant  float64
+	)
+
+	for _, t := range terms(expr, 1, nil) {
+		if t.expr == nil {
+			constant += t.coef
+			continue
+		}
+
+		found := false
+		for i := range collected {
+			if s.same(collected[i].expr, t.expr) {
+				collected[i].coef += t.coef
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			collected = append(collected, t)
+		}
+	}
+
+	var sum Expression = num(0)
+	for _, t := range append(collected, term{coef: constant}) {
+		var multiple Expression = num(math.Abs(t.coef))
+		if t.expr != nil {
+			if t.coef == 0 && s.opts.IgnoreNaN {
+				continue
+			}
+
+			multiple = s.mul(num(math.Abs(t.coef)), t.expr)
+		}
+
+		if math.Signbit(t.coef) {
+			sum = s.sub(sum, multiple)
+		} else {
+			sum = s.add(sum, multiple)
+		}
+	}
+
+	return sum
+}
+
+// factors splits a product into its factors, as powers with constant exponents.
+// Constant factors are multiplied into coef.
+func factors(expr Expression, coef *float64, acc []term) []term {
+	if x, ok := numberOf(expr); ok {
+		*coef *= x
+		return acc
+	}
+
+	if e, ok := unparen(expr).(BinaryOp); ok {
+		switch e.Op {
+		case "*":
+			return factors(e.Right, coef, factors(e.Left, coef, acc))
+		case "^":
+			if n, ok := numberOf(e.Right); ok {
+				return append(acc, term{coef: n, expr: e.Left})
+			}
+		}
+	}
+
+	return append(acc, term{coef: 1, expr: expr})
+}
+
+// natural reports whether x is 0, 1, 2 and so on.
+func natural(x float64) bool {
+	return x >= 0 && x == math.Trunc(x)
+}
+
+// collectFactors multiplies out the powers of the same expressions in a product.
+// Unless NaN is ignored, only natural exponents add up: x^0.5 * x^0.5 is NaN
+// for negative x, x^-1 * x^2 for 0, where x is not.
+func (s simplifier) collectFactors(expr Expression) Expression {
+	if !s.opts.CollectTerms {
+		return expr
+	}
+
+	coef := 1.0
+	var collected []term
+	for _, f := range factors(expr, &coef, nil) {
+		found := false
+		for i := range collected {
+			if s.same(collected[i].expr, f.expr) && (s.opts.IgnoreNaN || natural(collected[i].coef) && natural(f.coef)) {
+				collected[i].coef += f.coef
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			collected = append(collected, f)
+		}
+	}
+
+	var product Expression = num(coef)
+	for _, f := range collected {
+		product = s.mul(product, s.pow(f.expr, num(f.coef)))
+	}
+
+	return product
+}
@@ -0,0 +1,213 @@
+package calculon
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Kind names a type of values, such as "number". Kinds of custom values
+// should not clash with the predefined ones.
+type Kind string
+
+const (
+	KindNumber Kind = "number"
+	KindVector Kind = "vector"
+	KindBool   Kind = "bool"
+	KindString Kind = "string"
+)
+
+// scalar is implemented by values that may stand for a real number,
+// such as complex numbers with no imaginary part. They can be used
+// wherever a number is expected.
+type scalar interface {
+	Value
+	float() (float64, bool)
+}
+
+// Value is the result of evaluating an expression. Besides the predefined
+// kinds, values may be of any type, operators on them are defined with
+// RegisterInfixValue, RegisterPrefixValue and RegisterPostfixValue.
+type Value interface {
+	Kind() Kind
+	String() string
+}
+
+// Float is a scalar value.
+type Float float64
+
+func (f Float) Kind() Kind { return KindNumber }
+
+func (f Float) String() string {
+	return formatFloat(float64(f))
+}
+
+// Vector is a list of numbers: [1, 2, 3].
+// Arithmetic on vectors works element-wise, and a scalar operand is applied
+// to every element, so [1, 2] + [3, 4] is [4, 6] and [1, 2] * 2 is [2, 4].
+type Vector []float64
+
+func (v Vector) Kind() Kind { return KindVector }
+
+func (v Vector) String() string {
+	elems := make([]string, 0, len(v))
+	for _, x := range v {
+		elems = append(elems, formatFloat(x))
+	}
+
+	return "[" + strings.Join(elems, ", ") + "]"
+}
+
+// Bool is a truth value. Comparisons of numbers give 1 and 0 rather than
+// Bool values, but operators on other kinds may return them, and both work
+// as conditions.
+type Bool bool
+
+func (b Bool) Kind() Kind { return KindBool }
+
+func (b Bool) String() string { return strconv.FormatBool(bool(b)) }
+
+// String is a text value.
+type String string
+
+func (s String) Kind() Kind { return KindString }
+
+func (s String) String() string { return strconv.Quote(string(s)) }
+
+// formatFloat returns the shortest form of x that parses back to the same value,
+// without switching to the exponent form for ordinary integers.
+func formatFloat(x float64) string {
+	if x == math.Trunc(x) && math.Abs(x) < 1e21 {
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+
+	return strconv.FormatFloat(x, 'g', -1, 64)
+}
+
+// number unwraps the scalar result of EvalValue.
+func number(val Value, err error) (float64, error) {
+	if err != nil {
+		return 0, err
+	}
+
+	f, ok := realValue(val)
+	if !ok {
+		return 0, fmt.Errorf("%s used as a number: %s", val.Kind(), val)
+	}
+
+	return float64(f), nil
+}
+
+// realValue returns val as a Float, if it stands for a real number.
+func realValue(val Value) (Float, bool) {
+	switch val := val.(type) {
+	case Float:
+		return val, true
+	case scalar:
+		f, ok := val.float()
+		return Float(f), ok
+	default:
+		return 0, false
+	}
+}
+
+// truthy tells whether a value used as a condition is true.
+func truthy(val Value) (bool, error) {
+	if b, ok := val.(Bool); ok {
+		return bool(b), nil
+	}
+
+	f, ok := realValue(val)
+	if !ok {
+		return false, fmt.Errorf("%s used as a condition: %s", val.Kind(), val)
+	}
+
+	return f != 0, nil
+}
+
+// mapUnary applies fn to a scalar or to each element of a vector.
+func mapUnary(fn UnaryFunc, x Value) (Value, error) {
+	if v, ok := x.(Vector); ok {
+		result := make(Vector, len(v))
+		for i, elem := range v {
+			val, err := fn(elem)
+			if err != nil {
+				return nil, err
+			}
+
+			result[i] = val
+		}
+
+		return result, nil
+	}
+
+	f, err := number(x, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := fn(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return Float(val), nil
+}
+
+// mapBinary applies fn to scalars, or element-wise to vectors
+// of the same length. A scalar is paired with every element of a vector.
+func mapBinary(fn InfixFunc, l, r Value) (Value, error) {
+	lv, lvec := l.(Vector)
+	rv, rvec := r.(Vector)
+
+	switch {
+	case lvec && rvec:
+		if len(lv) != len(rv) {
+			return nil, fmt.Errorf("vector lengths differ: %d and %d", len(lv), len(rv))
+		}
+
+		result := make(Vector, len(lv))
+		for i := range lv {
+			val, err := fn(lv[i], rv[i])
+			if err != nil {
+				return nil, err
+			}
+
+			result[i] = val
+		}
+
+		return result, nil
+	case lvec:
+		right, err := number(r, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return mapUnary(func(x float64) (float64, error) { return fn(x, right) }, l)
+	case rvec:
+		left, err := number(l, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return mapUnary(func(x float64) (float64, error) { return fn(left, x) }, r)
+	}
+
+	left, err := number(l, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	right, err := number(r, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := fn(left, right)
+	if err != nil {
+		return nil, err
+	}
+
+	return Float(val), nil
+}
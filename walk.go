@@ -0,0 +1,116 @@
+package calculon
+
+// Visitor's Visit method is called for each expression met by Walk. If the
+// visitor w it returns is not nil, Walk visits each child of the expression
+// with w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(expr Expression) (w Visitor)
+}
+
+// Walk traverses an expression tree in depth-first order, like go/ast.Walk:
+// it calls v.Visit(expr), then walks the children of expr, see Expression.Children,
+// with the visitor returned, unless it is nil.
+func Walk(v Visitor, expr Expression) {
+	if v = v.Visit(expr); v == nil {
+		return
+	}
+
+	for _, child := range expr.Children() {
+		Walk(v, child)
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(Expression) bool
+
+func (f inspector) Visit(expr Expression) Visitor {
+	if f(expr) {
+		return f
+	}
+
+	return nil
+}
+
+// Inspect traverses an expression tree in depth-first order, calling f for each
+// expression. If f returns true, Inspect goes on with the children of the
+// expression, followed by a call of f(nil):
+//
+//	Inspect(expr, func(e Expression) bool {
+//		if v, ok := e.(Variable); ok {
+//			fmt.Println(v.Name)
+//		}
+//		return true
+//	})
+func Inspect(expr Expression, f func(Expression) bool) {
+	Walk(inspector(f), expr)
+}
+
+// Rewrite transforms an expression tree bottom-up: it rewrites the children of
+// expr first, then returns f called with expr rebuilt from the new children.
+// f returns its argument for expressions it keeps. The tree is not modified,
+// the nodes on the paths to the rewritten ones are copied:
+//
+//	Rewrite(expr, func(e Expression) Expression {
+//		if paren, ok := e.(Parentheses); ok {
+//			return paren.Expr
+//		}
+//		return e
+//	})
+//
+// Children of expressions other than those of this package are not rewritten,
+// as there is no way to rebuild them.
+func Rewrite(expr Expression, f func(Expression) Expression) Expression {
+	children := expr.Children()
+	if len(children) == 0 {
+		return f(expr)
+	}
+
+	rewritten := make([]Expression, len(children))
+	for i, child := range children {
+		rewritten[i] = Rewrite(child, f)
+	}
+
+	return f(withChildren(expr, rewritten))
+}
+
+// withChildren returns a copy of expr with its children, as given by Children, replaced.
+func withChildren(expr Expression, children []Expression) Expression {
+	switch expr := expr.(type) {
+	case BinaryOp:
+		expr.Left, expr.Right = children[0], children[1]
+		return expr
+	case UnaryOp:
+		expr.Expr = children[0]
+		return expr
+	case Conditional:
+		expr.Cond, expr.Then, expr.Else = children[0], children[1], children[2]
+		return expr
+	case Parentheses:
+		expr.Expr = children[0]
+		return expr
+	case FunctionCall:
+		expr.Args = children
+		return expr
+	case List:
+		expr.Items = children
+		return expr
+	case Index:
+		expr.Expr, expr.Index = children[0], children[1]
+		return expr
+	case Assignment:
+		expr.Value = children[0]
+		return expr
+	case FunctionDef:
+		expr.Body = children[0]
+		return expr
+	case Lambda:
+		expr.Body = children[0]
+		return expr
+	case Let:
+		expr.Bindings, expr.Body = children[:len(children)-1], children[len(children)-1]
+		return expr
+	default:
+		return expr
+	}
+}